@@ -0,0 +1,25 @@
+package kwlib
+
+// Returns true once the Paginator has released its context.
+func (P *Paginator) Released() bool {
+	return P.ctx.Err() != nil
+}
+
+// Returns a function restoring the error code registry to its current state.
+func SaveKWErrors() (restore func()) {
+	kw_error_lock.RLock()
+	defer kw_error_lock.RUnlock()
+
+	codes := make(map[string]kw_error_code, len(kw_error_codes))
+	for k, v := range kw_error_codes {
+		codes[k] = v
+	}
+	prefixes := append([]kw_error_prefix(nil), kw_error_prefixes...)
+
+	return func() {
+		kw_error_lock.Lock()
+		defer kw_error_lock.Unlock()
+		kw_error_codes = codes
+		kw_error_prefixes = prefixes
+	}
+}
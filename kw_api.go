@@ -2,6 +2,7 @@ package kwlib
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/tls"
@@ -14,25 +15,44 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
 type KWAPI struct {
 	Server         string        // kiteworks host name.
+	BaseURL        string        // Base URL for kiteworks (scheme://host:port/prefix), overrides Server when set.
 	ApplicationID  string        // Application ID set for kiteworks custom app.
 	RedirectURI    string        // Redirect URI for kiteworks custom app.
 	AgentString    string        // Agent-String header for calls to kiteworks.
 	VerifySSL      bool          // Verify certificate for connections.
 	ProxyURI       string        // Proxy for outgoing https requests.
-	Snoop          bool          // Flag to snoop API calls
+	Snoop          bool          // Flag to snoop API calls, writes SnoopTracer output when Tracer is not set.
+	Tracer         Tracer        // Receives requests, responses, retries and token refreshes.
+	Redactor       *Redactor     // Rules for hiding sensitive values from traces, defaults to DefaultRedactor().
+	Metrics        Metrics       // Receives counters and histograms for calls, retries, token requests and transfers.
 	RequestTimeout time.Duration // Timeout for request to be answered from kiteworks server.
 	ConnectTimeout time.Duration // Timeout for TLS connection to kiteworks server.
+	IdleTimeout    time.Duration // Time an idle connection is kept open for reuse, defaults to 90 seconds.
+	MaxIdleConns   int           // Max idle connections kept open per kiteworks host, defaults to 16.
+	EnableHTTP2    bool          // Attempt HTTP/2 connections to kiteworks.
 	MaxChunkSize   int64         // Max Upload Chunksize in bytes, min = 1M, max = 68M
 	Retries        uint          // Max retries on a failed call
+	RetryPolicy    RetryPolicy   // Decides which failures are retried and the delay between attempts, defaults to (attempt^2) seconds.
+	UploadThreads  int           // Number of upload chunks to send concurrently, 0 or 1 sends chunks sequentially.
+	UploadBuffer   int64         // Max bytes of chunks held in memory when sending concurrently from a source without io.ReaderAt, defaults to 64M.
+	StreamSpool    string        // Directory UploadStream spools streams larger than a chunk to, defaults to the system temporary directory.
+	StreamSpoolMax int64         // Max bytes UploadStream spools, larger streams fail with ErrStreamTooLarge, 0 for no limit.
+	PageThreads    int           // Number of pages DataCall fetches concurrently, 0 or 1 fetches pages sequentially.
 	TokenStore     TokenStore    // TokenStore for reading and writing auth tokens securely.
 	secrets        kwapi_secrets // Encrypted config options such as signature token, client secret key.
 	limiter        chan struct{} // Implements a limiter for API calls to appliance.
 	trans_limiter  chan struct{} // Implements a file transfer limiter.
+	rate_limiter   *rate_limiter // Implements a requests per second limiter for API calls.
+	transport      *http.Transport
+	transport_lock sync.Mutex
+	chunk_gaps     map[int][]int64 // Chunks not yet accepted by kiteworks for concurrent uploads which failed, by upload ID.
+	chunk_lock     sync.Mutex
 }
 
 // Configures maximum number of simultaneous api calls.
@@ -52,6 +72,56 @@ func (K *KWAPI) SetTransferLimiter(max_transfers int) {
 	}
 	if K.trans_limiter == nil {
 		K.trans_limiter = make(chan struct{}, max_transfers)
+		for i := 0; i < max_transfers; i++ {
+			K.trans_limiter <- struct{}{}
+		}
+	}
+}
+
+// Returns the base URL for kiteworks requests, built from BaseURL or Server.
+func (K *KWAPI) baseURL() (*url.URL, error) {
+	if K.BaseURL == NONE {
+		return &url.URL{Scheme: "https", Host: K.Server}, nil
+	}
+	u, err := url.Parse(K.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == NONE || u.Host == NONE {
+		return nil, fmt.Errorf("BaseURL must include scheme and host: %s", K.BaseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawQuery = NONE
+	u.Fragment = NONE
+	return u, nil
+}
+
+// Returns full URL for path on kiteworks.
+func (K *KWAPI) endpoint(path string) (string, error) {
+	u, err := K.baseURL()
+	if err != nil {
+		return NONE, err
+	}
+	return u.String() + path, nil
+}
+
+// Returns kiteworks host for display purposes.
+func (K *KWAPI) hostname() string {
+	if u, err := K.baseURL(); err == nil && u.Host != NONE {
+		return u.Host
+	}
+	return K.Server
+}
+
+// Sleeps for the duration specified, returns early if ctx is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
 }
 
@@ -87,32 +157,34 @@ func KVLiteStore(input *Database) *kvLiteStore {
 
 // Save token to TokenStore
 func (T kvLiteStore) Save(username string, auth *KWAuth) error {
-	T.Database.CryptSet("KWAPI_tokens", username, &auth)
-	return nil
+	return T.Database.TryCryptSet("KWAPI_tokens", username, &auth)
 }
 
 // Retrieve token from TokenStore
 func (T *kvLiteStore) Load(username string) (*KWAuth, error) {
 	var auth *KWAuth
-	T.Database.Get("KWAPI_tokens", username, &auth)
+	if _, err := T.Database.TryGet("KWAPI_tokens", username, &auth); err != nil {
+		return nil, err
+	}
 	return auth, nil
 }
 
 // Remove token from TokenStore
 func (T *kvLiteStore) Delete(username string) error {
-	T.Database.Unset("KWAPI_tokens", username)
-	return nil
+	return T.Database.TryUnset("KWAPI_tokens", username)
 }
 
 // Encryption function for storing signature and client secrets.
-func (k *kwapi_secrets) encrypt(input string) []byte {
+func (k *kwapi_secrets) encrypt(input string) ([]byte, error) {
 
 	if k.key == nil {
 		k.key = RandBytes(32)
 	}
 
 	block, err := aes.NewCipher(k.key)
-	Critical(err)
+	if err != nil {
+		return nil, err
+	}
 	in_bytes := []byte(input)
 
 	buff := make([]byte, len(in_bytes))
@@ -120,7 +192,7 @@ func (k *kwapi_secrets) encrypt(input string) []byte {
 
 	cipher.NewCFBEncrypter(block, k.key[0:block.BlockSize()]).XORKeyStream(buff, buff)
 
-	return buff
+	return buff, nil
 }
 
 // Retrieves encrypted signature and client secrets.
@@ -161,6 +233,9 @@ func SetParams(vars ...interface{}) (output []interface{}) {
 	)
 
 	process_vars := func(vars interface{}) {
+		if q, ok := vars.(*QueryBuilder); ok {
+			vars = q.Query()
+		}
 		switch x := vars.(type) {
 		case Query:
 			if query == nil {
@@ -190,7 +265,7 @@ func SetParams(vars ...interface{}) (output []interface{}) {
 	}
 
 	for {
-		tmp := vars[0:0]
+		var tmp []interface{}
 		for _, v := range vars {
 			switch val := v.(type) {
 			case []interface{}:
@@ -238,7 +313,7 @@ type KWAPIClient struct {
 }
 
 func (c *KWAPIClient) Do(req *http.Request) (resp *http.Response, err error) {
-	resp, err = c.Client.Do(req)
+	resp, err = c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -248,13 +323,15 @@ func (c *KWAPIClient) Do(req *http.Request) (resp *http.Response, err error) {
 }
 
 // Sets signature key.
-func (K *KWAPI) Signature(signature_key string) {
-	K.secrets.signature_key = K.secrets.encrypt(signature_key)
+func (K *KWAPI) Signature(signature_key string) (err error) {
+	K.secrets.signature_key, err = K.secrets.encrypt(signature_key)
+	return
 }
 
 // Sets client secret key.
-func (K *KWAPI) ClientSecret(client_secret_key string) {
-	K.secrets.client_secret_key = K.secrets.encrypt(client_secret_key)
+func (K *KWAPI) ClientSecret(client_secret_key string) (err error) {
+	K.secrets.client_secret_key, err = K.secrets.encrypt(client_secret_key)
+	return
 }
 
 // kiteworks Auth token.
@@ -269,14 +346,15 @@ type KWAuth struct {
 type KWSession struct {
 	Username string
 	*KWAPI
+	plan *Plan
 }
 
 // Wraps a session for specfiied user.
 func (K *KWAPI) Session(username string) KWSession {
-	return KWSession{username, K}
+	return KWSession{Username: username, KWAPI: K}
 }
 
-// Prints arrays for string and int arrays, when submitted to Queries or Form post.
+// Prints arrays for string and int arrays, and timestamps for time.Time, when submitted to Queries or Form post.
 func Spanner(input interface{}) string {
 	switch v := input.(type) {
 	case []string:
@@ -287,6 +365,14 @@ func Spanner(input interface{}) string {
 			output = append(output, fmt.Sprintf("%v", i))
 		}
 		return strings.Join(output, ",")
+	case []int64:
+		var output []string
+		for _, i := range v {
+			output = append(output, fmt.Sprintf("%v", i))
+		}
+		return strings.Join(output, ",")
+	case time.Time:
+		return WriteKWTime(v)
 	default:
 		return fmt.Sprintf("%v", input)
 	}
@@ -296,129 +382,134 @@ func Spanner(input interface{}) string {
 func (K *KWAPI) decodeJSON(resp *http.Response, output interface{}) (err error) {
 	defer resp.Body.Close()
 
-	var (
-		snoop_output map[string]interface{}
-		snoop_buffer bytes.Buffer
-		body         io.Reader
-	)
-
 	resp.Body = iotimeout.NewReadCloser(resp.Body, K.RequestTimeout)
 
-	if K.Snoop {
-		if output == nil {
-			Snoop("<-- RESPONSE STATUS: %s", resp.Status)
-			dec := json.NewDecoder(resp.Body)
-			dec.Decode(&snoop_output)
-			if len(snoop_output) > 0 {
-				o, _ := json.MarshalIndent(&snoop_output, "", "  ")
-					Snoop("<-- RESPONSE BODY: \n%s\n", string(o))
-			}
-			return nil
-		} else {
-			Snoop("<-- RESPONSE STATUS: %s", resp.Status)
-			body = io.TeeReader(resp.Body, &snoop_buffer)
-		}
-	} else {
-		body = resp.Body
-	}
-
 	if output == nil {
 		return nil
 	}
 
-	dec := json.NewDecoder(body)
+	dec := json.NewDecoder(resp.Body)
 	err = dec.Decode(output)
 	if err == io.EOF {
 		return nil
 	}
 
 	if err != nil {
-		if K.Snoop {
-			txt := snoop_buffer.String()
-			if err := snoop_request(&snoop_buffer); err != nil {
-				Stdout(txt)
-			}
-			err = fmt.Errorf("I cannot understand what %s is saying: %s", K.Server, err.Error())
-			return
+		if K.tracer() != nil {
+			err = fmt.Errorf("I cannot understand what %s is saying: %s", K.hostname(), err.Error())
 		} else {
-			err = fmt.Errorf("I cannot understand what %s is saying. (Try enabling snoop): %s", K.Server, err.Error())
-			return
+			err = fmt.Errorf("I cannot understand what %s is saying. (Try enabling snoop): %s", K.hostname(), err.Error())
 		}
 	}
-
-	if K.Snoop {
-		snoop_request(&snoop_buffer)
-	}
 	return
 }
 
-// Provides output of specified request.
-func snoop_request(body io.Reader) error {
-	var snoop_generic map[string]interface{}
-	dec := json.NewDecoder(body)
-	if err := dec.Decode(&snoop_generic); err != nil {
-		return err
+// Returns transport shared by all sessions, building it on first use.
+// Connection settings are read when the transport is built, call Close to apply changes.
+func (K *KWAPI) getTransport() (*http.Transport, error) {
+	K.transport_lock.Lock()
+	defer K.transport_lock.Unlock()
+
+	if K.transport != nil {
+		return K.transport, nil
 	}
-	if snoop_generic != nil {
-		for v, _ := range snoop_generic {
-			switch v {
-			case "refresh_token":
-				fallthrough
-			case "access_token":
-				snoop_generic[v] = "[HIDDEN]"
-			}
-		}
+
+	transport := &http.Transport{
+		MaxIdleConns:          K.MaxIdleConns,
+		MaxIdleConnsPerHost:   K.MaxIdleConns,
+		IdleConnTimeout:       K.IdleTimeout,
+		TLSHandshakeTimeout:   K.ConnectTimeout,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     K.EnableHTTP2,
 	}
-	o, _ := json.MarshalIndent(&snoop_generic, "", "  ")
-	Snoop("<-- RESPONSE BODY: \n%s\n", string(o))
-	return nil
-}
 
-// kiteworks Client
-func (s KWSession) NewClient() *KWAPIClient {
-	var transport http.Transport
+	if transport.MaxIdleConns <= 0 {
+		transport.MaxIdleConns = 16
+		transport.MaxIdleConnsPerHost = 16
+	}
+
+	if transport.IdleConnTimeout <= 0 {
+		transport.IdleConnTimeout = 90 * time.Second
+	}
 
 	// Allows invalid certs if set to "no" in config.
-	if !s.VerifySSL {
+	if !K.VerifySSL {
 		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
 
-	if s.ProxyURI != NONE {
-		proxyURL, err := url.Parse(s.ProxyURI)
-		Critical(err)
+	if K.ProxyURI != NONE {
+		proxyURL, err := url.Parse(K.ProxyURI)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ProxyURI %s: %s", K.ProxyURI, err.Error())
+		}
 		transport.Proxy = http.ProxyURL(proxyURL)
 	}
 
-	transport.Dial = (&net.Dialer{
-		Timeout: s.ConnectTimeout,
-	}).Dial
+	transport.DialContext = (&net.Dialer{
+		Timeout:   K.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+
+	K.transport = transport
+	return transport, nil
+}
 
-	transport.TLSHandshakeTimeout = s.ConnectTimeout
+// Closes idle connections held by the shared transport, a new transport is built on the next call.
+func (K *KWAPI) Close() {
+	K.transport_lock.Lock()
+	defer K.transport_lock.Unlock()
 
-	return &KWAPIClient{&s, &http.Client{Transport: &transport, Timeout: s.RequestTimeout}}
+	if K.transport != nil {
+		K.transport.CloseIdleConnections()
+		K.transport = nil
+	}
+}
+
+// kiteworks Client
+func (s KWSession) TryNewClient() (*KWAPIClient, error) {
+	transport, err := s.getTransport()
+	if err != nil {
+		return nil, err
+	}
+	return &KWAPIClient{&s, &http.Client{Transport: transport, Timeout: s.RequestTimeout}}, nil
+}
+
+// kiteworks Client, fatal on error.
+func (s KWSession) NewClient() *KWAPIClient {
+	client, err := s.TryNewClient()
+	Critical(err)
+	return client
 }
 
 // New kiteworks Request.
 func (s KWSession) NewRequest(method, path string, api_ver int) (req *http.Request, err error) {
+	return s.NewRequestContext(context.Background(), method, path, api_ver)
+}
+
+// New kiteworks Request bound to ctx, token refreshes and the request itself are cancelled with ctx.
+func (s KWSession) NewRequestContext(ctx context.Context, method, path string, api_ver int) (req *http.Request, err error) {
 
 	// Set API Version
 	if api_ver == 0 {
 		api_ver = 11
 	}
 
-	req, err = http.NewRequest(method, fmt.Sprintf("https://%s%s", s.Server, path), nil)
+	base, err := s.baseURL()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, method, base.String()+path, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.URL.Host = s.Server
-	req.URL.Scheme = "https"
 	req.Header.Set("X-Accellion-Version", fmt.Sprintf("%d", api_ver))
 	if s.AgentString == NONE {
 		s.AgentString = "kwlib/1.0"
 	}
 	req.Header.Set("User-Agent", s.AgentString)
-	req.Header.Set("Referer", "https://"+s.Server+"/")
+	req.Header.Set("Referer", base.String()+"/")
 
 	if err := s.setToken(req, false); err != nil {
 		return nil, err
@@ -429,33 +520,41 @@ func (s KWSession) NewRequest(method, path string, api_ver int) (req *http.Reque
 
 // kiteworks API Call Wrapper
 func (s KWSession) Call(api_req APIRequest) (err error) {
+	return s.CallContext(context.Background(), api_req)
+}
+
+// kiteworks API Call Wrapper, aborts retries, limiter waits and token refreshes when ctx is done.
+func (s KWSession) CallContext(ctx context.Context, api_req APIRequest) (err error) {
+	if planned, err := s.planCall(api_req); planned {
+		return err
+	}
+
 	if s.limiter != nil {
-		s.limiter<-struct{}{}
-		defer func() { <-s.limiter }()
+		select {
+		case s.limiter <- struct{}{}:
+			defer func() { <-s.limiter }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
-	req, err := s.NewRequest(api_req.Method, api_req.Path, api_req.APIVer)
+	req, err := s.NewRequestContext(ctx, api_req.Method, api_req.Path, api_req.APIVer)
 	if err != nil {
 		return err
 	}
 
-	if s.Snoop {
-		Snoop("[kiteworks snoop]: %s", s.Username)
-		Snoop("--> METHOD: \"%s\" PATH: \"%s\"", strings.ToUpper(api_req.Method), api_req.Path)
-	}
-
 	var body []byte
 
 	for _, in := range api_req.Params {
+		if q, ok := in.(*QueryBuilder); ok {
+			in = q.Query()
+		}
 		switch i := in.(type) {
 		case PostForm:
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 			p := make(url.Values)
 			for k, v := range i {
 				p.Add(k, Spanner(v))
-				if s.Snoop {
-					Snoop("\\-> POST PARAM: \"%s\" VALUE: \"%s\"", k, p[k])
-				}
 			}
 			body = []byte(p.Encode())
 		case PostJSON:
@@ -464,17 +563,11 @@ func (s KWSession) Call(api_req APIRequest) (err error) {
 			if err != nil {
 				return err
 			}
-			if s.Snoop {
-				Snoop("\\-> POST JSON: %s", string(json))
-			}
 			body = json
 		case Query:
 			q := req.URL.Query()
 			for k, v := range i {
 				q.Set(k, Spanner(v))
-				if s.Snoop {
-					Snoop("\\-> QUERY: %s=%s", k, q[k])
-				}
 			}
 			req.URL.RawQuery = q.Encode()
 		case nil:
@@ -486,59 +579,54 @@ func (s KWSession) Call(api_req APIRequest) (err error) {
 
 	var resp *http.Response
 
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	client, err := s.TryNewClient()
+	if err != nil {
+		return err
+	}
+
 	// Retry calls on failure.
 	for i := 0; i <= int(s.Retries); i++ {
-		reAuth := func(s *KWSession, req *http.Request, orig_err error) error {
-			if s.secrets.signature_key == nil {
-				existing, err := s.TokenStore.Load(s.Username)
-				if err != nil {
-					return err
-				}
-				if token, err := s.refreshToken(s.Username, existing); err == nil {
-					if err := s.TokenStore.Save(s.Username, token); err != nil {
-						return err
-					}
-					if err = s.setToken(req, false); err == nil {
-						return nil
-					}
-				}
-				s.TokenStore.Delete(s.Username)
-				Critical(fmt.Errorf("Token is no longer valid: %s", orig_err.Error()))
-			}
-			return s.setToken(req, KWAPIError(err, TOKEN_ERR))
+		if err := s.rateWait(ctx); err != nil {
+			return err
 		}
-
 		req.Body = ioutil.NopCloser(bytes.NewReader(body))
-		client := s.NewClient()
-		resp, err = client.Do(req)
-		if err != nil && KWAPIError(err, ERR_INTERNAL_SERVER_ERROR|TOKEN_ERR) {
-			if KWAPIError(err, TOKEN_ERR) {
-				if err := reAuth(&s, req, err); err != nil {
-					return err
-				}
-			}
-			Debug("(CALL ERROR) %s -> %s: %s (%d/%d)", s.Username, api_req.Path, err.Error(), i+1, s.Retries+1)
-			time.Sleep((time.Second * time.Duration(i+1)) * time.Duration(i+1))
-			continue
-		} else if err != nil {
-			if !IsKWError(err) {
-				Warn("%s -> %s: %s (%d/%d)", s.Username, api_req.Path, err.Error(), i+1, s.Retries+1)
-				time.Sleep((time.Second * time.Duration(i+1)) * time.Duration(i+1))
-				continue
+		resp, err = client.Do(req.WithContext(withAttempt(ctx, i+1)))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			if err = s.decodeJSON(resp, api_req.Output); err == nil {
+				break
 			}
+		}
+
+		wait, retry := s.shouldRetry(i, err, resp)
+		if !retry {
 			break
 		}
 
-		err = s.decodeJSON(resp, api_req.Output)
-		if err != nil && KWAPIError(err, ERR_INTERNAL_SERVER_ERROR|TOKEN_ERR) {
+		if IsKWError(err) {
 			Debug("(CALL ERROR) %s -> %s: %s (%d/%d)", s.Username, api_req.Path, err.Error(), i+1, s.Retries+1)
-			if err := reAuth(&s, req, err); err != nil {
+		} else {
+			Warn("%s -> %s: %s (%d/%d)", s.Username, api_req.Path, err.Error(), i+1, s.Retries+1)
+		}
+		s.traceRetry(api_req.Method, req.URL.Path, i+1, err, wait)
+
+		if KWAPIError(err, TOKEN_ERR) {
+			if err := s.renewToken(ctx, err); err != nil {
+				return err
+			}
+			if err := s.setToken(req, false); err != nil {
 				return err
 			}
-			time.Sleep((time.Second * time.Duration(i+1)) * time.Duration(i+1))
-			continue
-		} else {
-			break
+		}
+
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
 		}
 	}
 	return
@@ -547,6 +635,12 @@ func (s KWSession) Call(api_req APIRequest) (err error) {
 // Call handler which allows for easier getting of multiple-object arrays.
 // An offset of -1 will provide all results, any positive offset will only return the requested results.
 func (s KWSession) DataCall(req APIRequest, offset, limit int) (err error) {
+	return s.DataCallContext(context.Background(), req, offset, limit)
+}
+
+// DataCall bound to ctx, stops fetching further pages once ctx is done.
+// When PageThreads is above 1, pages following the first are fetched concurrently using the total reported by kiteworks.
+func (s KWSession) DataCallContext(ctx context.Context, req APIRequest, offset, limit int) (err error) {
 
 	output := req.Output
 	params := req.Params
@@ -564,59 +658,118 @@ func (s KWSession) DataCall(req APIRequest, offset, limit int) (err error) {
 		managed = true
 	}
 
-	var o struct {
-		Data interface{} `json:"data"`
-	}
-
-	o.Data = req.Output
-
-	var tmp []map[string]interface{}
+	// Stack the raw elements of each page, then decode the stack to the original request.
+	var stack []json.RawMessage
 
-	var enc_buff bytes.Buffer
-	enc := json.NewEncoder(&enc_buff)
-	dec := json.NewDecoder(&enc_buff)
-
-	// Get response, decode it to a generic array of map[string]interface{}.
-	// Stack responses, them, and then encode the stack, then decode to original request.
 	for {
-		req.Params = SetParams(params, Query{"limit": limit, "offset": offset})
-		req.Output = &o
-		if err = s.Call(req); err != nil {
+		page, total, err := s.dataPage(ctx, req, params, offset, limit)
+		if err != nil {
 			return err
 		}
-		// Decode the results we get, convert to []map[string]interface{}, and stack results.
-		if o.Data != nil {
-			enc_buff.Reset()
-			err := enc.Encode(o.Data)
+		stack = append(stack, page...)
+		if len(page) < limit || managed {
+			break
+		}
+		offset = offset + limit
+
+		if s.PageThreads > 1 && total > offset {
+			pages, err := s.dataPages(ctx, req, params, offset, limit, total)
 			if err != nil {
 				return err
 			}
-			var t []map[string]interface{}
-			err = dec.Decode(&t)
-			if err != nil {
-				return err
+			for _, p := range pages {
+				stack = append(stack, p...)
 			}
-			tmp = append(tmp, t[0:]...)
-			if len(t) < limit || managed {
+			// Continue past the total only if the last page was full, as entries may have been added meanwhile.
+			if len(pages[len(pages)-1]) < limit {
 				break
-			} else {
-				offset = offset + limit
 			}
-		} else {
-			return fmt.Errorf("Something unexpected happened, got an empty response.")
+			offset = offset + len(pages)*limit
 		}
 	}
 
-	enc_buff.Reset()
-
-	// Take stack of results we recevied and decode it back to the original object.
-	if err := enc.Encode(tmp); err != nil {
+	enc, err := json.Marshal(stack)
+	if err != nil {
 		return err
-	} else {
-		tmp = nil
-		if err = dec.Decode(output); err != nil {
-			return err
+	}
+	stack = nil
+	return json.Unmarshal(enc, output)
+}
+
+// Fetches a single page of a list request, returns its elements and the total reported by kiteworks, -1 if not provided.
+func (s KWSession) dataPage(ctx context.Context, req APIRequest, params []interface{}, offset, limit int) ([]json.RawMessage, int, error) {
+	var o struct {
+		Data     []json.RawMessage `json:"data"`
+		Metadata struct {
+			Total *int `json:"total"`
+		} `json:"metadata"`
+	}
+
+	req.Params = SetParams(Query{}, params, Query{"limit": limit, "offset": offset})
+	req.Output = &o
+
+	if err := s.CallContext(ctx, req); err != nil {
+		return nil, -1, err
+	}
+	if o.Data == nil {
+		return nil, -1, fmt.Errorf("Something unexpected happened, got an empty response.")
+	}
+	if o.Metadata.Total == nil {
+		return o.Data, -1, nil
+	}
+	return o.Data, *o.Metadata.Total, nil
+}
+
+// Fetches pages from offset up to total, up to PageThreads at a time, returned in order.
+func (s KWSession) dataPages(ctx context.Context, req APIRequest, params []interface{}, offset, limit, total int) ([][]json.RawMessage, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pages := make([][]json.RawMessage, (total-offset+limit-1)/limit)
+
+	var (
+		wg        sync.WaitGroup
+		err_lock  sync.Mutex
+		first_err error
+	)
+
+	threads := make(chan struct{}, s.PageThreads)
+
+	for i := range pages {
+		select {
+		case threads <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
 		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-threads
+				wg.Done()
+			}()
+			page, _, err := s.dataPage(ctx, req, params, offset+i*limit, limit)
+			if err != nil {
+				err_lock.Lock()
+				if first_err == nil {
+					first_err = err
+				}
+				err_lock.Unlock()
+				cancel()
+				return
+			}
+			pages[i] = page
+		}(i)
 	}
-	return
+
+	wg.Wait()
+
+	if first_err != nil {
+		return nil, first_err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return pages, nil
 }
@@ -0,0 +1,170 @@
+package kwlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Operation applied to many kiteworks entities.
+type BatchRequest struct {
+	Method   string        // HTTP method for both bulk and individual calls.
+	BulkPath string        // Bulk endpoint accepting an id:in query, ie.. /rest/files. Leave empty if there is none.
+	ItemPath string        // Endpoint for a single entity, with %d for the ID, ie.. /rest/files/%d.
+	Params   []interface{} // Params sent with every call.
+	APIVer   int           // API version, defaults to the version used by Call.
+	BulkSize int           // Max IDs sent per bulk call, defaults to 100.
+	Threads  int           // Individual calls made concurrently, defaults to 4.
+}
+
+// Outcome of a batch, nil for each ID which succeeded.
+type BatchResult map[int]error
+
+// Returns IDs which failed, in order.
+func (r BatchResult) Failed() (ids []int) {
+	for id, err := range r {
+		if err != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return
+}
+
+// Returns nil if every ID succeeded, otherwise an error summarizing the failures.
+func (r BatchResult) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	msg := make([]string, len(failed))
+	for i, id := range failed {
+		msg[i] = fmt.Sprintf("%d: %s", id, r[id].Error())
+	}
+	return fmt.Errorf("%d of %d failed: %s", len(failed), len(r), strings.Join(msg, "; "))
+}
+
+// Applies req to each of ids, grouping them into bulk calls where possible, returns the result for each ID.
+func (s KWSession) Batch(req BatchRequest, ids []int) BatchResult {
+	return s.BatchContext(context.Background(), req, ids)
+}
+
+// Batch bound to ctx, IDs not attempted before ctx is done report ctx.Err().
+//
+// A failed bulk call is retried as individual calls for its IDs, so each ID reports its own error.
+// When the bulk endpoint is not supported all remaining IDs are sent individually.
+func (s KWSession) BatchContext(ctx context.Context, req BatchRequest, ids []int) BatchResult {
+	result := make(BatchResult, len(ids))
+
+	bulk_size := req.BulkSize
+	if bulk_size <= 0 {
+		bulk_size = 100
+	}
+
+	var individual []int
+
+	for len(ids) > 0 {
+		n := bulk_size
+		if n > len(ids) {
+			n = len(ids)
+		}
+		group := ids[:n]
+		ids = ids[n:]
+
+		if req.BulkPath == NONE {
+			individual = append(individual, group...)
+			continue
+		}
+
+		err := s.CallContext(ctx, APIRequest{
+			Method: req.Method,
+			Path:   req.BulkPath,
+			Params: SetParams(Query{"id:in": group}, req.Params),
+			APIVer: req.APIVer,
+		})
+		if err == nil {
+			for _, id := range group {
+				result[id] = nil
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			individual = append(individual, group...)
+			continue
+		}
+		if bulkUnsupported(err) {
+			Debug("%s %s: bulk endpoint unavailable, sending calls individually: %s", req.Method, req.BulkPath, err.Error())
+			req.BulkPath = NONE
+		}
+		individual = append(individual, group...)
+	}
+
+	if len(individual) > 0 {
+		s.batchItems(ctx, req, individual, result)
+	}
+
+	return result
+}
+
+// Returns true if err shows the bulk endpoint itself is not available, as opposed to a failure of an entity.
+func bulkUnsupported(err error) bool {
+	if errors.Is(err, ErrRequestMethodNotAllowed) || errors.Is(err, Error("ERR_REQUEST_NOT_FOUND")) {
+		return true
+	}
+	var e *KWError
+	if errors.As(err, &e) {
+		return e.StatusCode() == 405 || e.StatusCode() == 501
+	}
+	return false
+}
+
+// Sends individual calls for ids, up to req.Threads at a time, recording each outcome to result.
+func (s KWSession) batchItems(ctx context.Context, req BatchRequest, ids []int, result BatchResult) {
+	threads := req.Threads
+	if threads <= 0 {
+		threads = 4
+	}
+
+	var (
+		wg          sync.WaitGroup
+		result_lock sync.Mutex
+	)
+
+	params := SetParams(req.Params)
+
+	limit := make(chan struct{}, threads)
+
+	for _, id := range ids {
+		select {
+		case limit <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			result_lock.Lock()
+			result[id] = ctx.Err()
+			result_lock.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(id int) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+			err := s.CallContext(ctx, APIRequest{
+				Method: req.Method,
+				Path:   SetPath(req.ItemPath, id),
+				Params: params,
+				APIVer: req.APIVer,
+			})
+			result_lock.Lock()
+			result[id] = err
+			result_lock.Unlock()
+		}(id)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,89 @@
+package kwlib_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cmcoffee/go-kwlib"
+)
+
+func TestBatch(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	var ids []int
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		ids = append(ids, srv.AddFile(user.BaseDirID, name, []byte(name)).ID)
+	}
+
+	if err := s.Files().DeleteMany(ids[:2]).Err(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[:2] {
+		if _, err := s.Files().Get(id); !errors.Is(err, kwlib.ErrEntityNotFound) {
+			t.Fatalf("file %d not deleted: %v", id, err)
+		}
+	}
+
+	// A bulk call failing on one ID falls back to individual calls, so the rest still succeed.
+	result := s.Files().DeleteMany([]int{ids[2], 99999})
+	if result[ids[2]] != nil || !errors.Is(result[99999], kwlib.ErrEntityNotFound) {
+		t.Fatalf("unexpected result %v", result)
+	}
+	if failed := result.Failed(); len(failed) != 1 || failed[0] != 99999 || result.Err() == nil {
+		t.Fatalf("failed %v, error %v", failed, result.Err())
+	}
+
+	dest, err := s.Folders().Create(user.BaseDirID, "Archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	folders := []int{
+		srv.AddFolder(user.BaseDirID, "one").ID,
+		srv.AddFolder(user.BaseDirID, "two").ID,
+	}
+	if err := s.Folders().MoveMany(folders, dest.ID).Err(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range folders {
+		if f, err := s.Folders().Get(id); err != nil || f.ParentID != dest.ID {
+			t.Fatalf("folder %d not moved: %+v, %v", id, f, err)
+		}
+	}
+}
+
+func TestBatchUnsupported(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	// Counts calls to the bulk endpoint, which the fake does not route.
+	var bulk_calls int32
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/rest/unsupported") {
+			atomic.AddInt32(&bulk_calls, 1)
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer plain.Close()
+	s.BaseURL = plain.URL
+
+	var ids []int
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		ids = append(ids, srv.AddFile(user.BaseDirID, name, []byte(name)).ID)
+	}
+
+	result := s.Batch(kwlib.BatchRequest{
+		Method:   "DELETE",
+		BulkPath: "/rest/unsupported",
+		ItemPath: "/rest/files/%d",
+		BulkSize: 1,
+	}, ids)
+	if err := result.Err(); err != nil || len(result) != 3 {
+		t.Fatalf("unexpected result %v, %v", result, err)
+	}
+	if n := atomic.LoadInt32(&bulk_calls); n != 1 {
+		t.Fatalf("bulk endpoint called %d times after it was found unsupported", n)
+	}
+}
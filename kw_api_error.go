@@ -1,14 +1,15 @@
 package kwlib
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cmcoffee/go-snuglib/iotimeout"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const (
@@ -28,58 +29,146 @@ const (
 	SERVICE_UNAVAILABLE
 	ERR_ENTITY_NOT_SCANNED
 	ERR_ENTITY_PARENT_FOLDER_MEMBER_EXISTS
+	ERR_TOO_MANY_REQUESTS
 )
 
 // Auth token related errors.
 const TOKEN_ERR = ERR_AUTH_PROFILE_CHANGED | ERR_INVALID_GRANT | ERR_AUTH_UNAUTHORIZED
 
+// Sentinel errors for kiteworks error codes, for use with errors.Is.
+const (
+	ErrAuthUnauthorized               = Error("ERR_AUTH_UNAUTHORIZED")
+	ErrAuthProfileChanged             = Error("ERR_AUTH_PROFILE_CHANGED")
+	ErrAccessUser                     = Error("ERR_ACCESS_USER")
+	ErrInvalidGrant                   = Error("INVALID_GRANT")
+	ErrEntityDeletedPermanently       = Error("ERR_ENTITY_DELETED_PERMANENTLY")
+	ErrEntityNotFound                 = Error("ERR_ENTITY_NOT_FOUND")
+	ErrEntityDeleted                  = Error("ERR_ENTITY_DELETED")
+	ErrEntityParentFolderDeleted      = Error("ERR_ENTITY_PARENT_FOLDER_DELETED")
+	ErrRequestMethodNotAllowed        = Error("ERR_REQUEST_METHOD_NOT_ALLOWED")
+	ErrInternalServerError            = Error("ERR_INTERNAL_SERVER_ERROR")
+	ErrEntityExists                   = Error("ERR_ENTITY_EXISTS")
+	ErrEntityRoleIsAssigned           = Error("ERR_ENTITY_ROLE_IS_ASSIGNED")
+	ErrUnavailable                    = Error("UNAVAILABLE")
+	ErrServiceUnavailable             = Error("SERVICE_UNAVAILABLE")
+	ErrEntityNotScanned               = Error("ERR_ENTITY_NOT_SCANNED")
+	ErrEntityParentFolderMemberExists = Error("ERR_ENTITY_PARENT_FOLDER_MEMBER_EXISTS")
+	ErrTooManyRequests                = Error("ERR_TOO_MANY_REQUESTS")
+)
+
+// Flags matched by each sentinel error.
+var sentinel_flags = map[Error]int64{
+	ErrAuthUnauthorized:               ERR_AUTH_UNAUTHORIZED,
+	ErrAuthProfileChanged:             ERR_AUTH_PROFILE_CHANGED,
+	ErrAccessUser:                     ERR_ACCESS_USER,
+	ErrInvalidGrant:                   ERR_INVALID_GRANT,
+	ErrEntityDeletedPermanently:       ERR_ENTITY_DELETED_PERMANENTLY,
+	ErrEntityNotFound:                 ERR_ENTITY_NOT_FOUND,
+	ErrEntityDeleted:                  ERR_ENTITY_DELETED,
+	ErrEntityParentFolderDeleted:      ERR_ENTITY_PARENT_FOLDER_DELETED,
+	ErrRequestMethodNotAllowed:        ERR_REQUEST_METHOD_NOT_ALLOWED,
+	ErrInternalServerError:            ERR_INTERNAL_SERVER_ERROR,
+	ErrEntityExists:                   ERR_ENTITY_EXISTS,
+	ErrEntityRoleIsAssigned:           ERR_ENTITY_ROLE_IS_ASSIGNED,
+	ErrUnavailable:                    UNAVAILABLE,
+	ErrServiceUnavailable:             SERVICE_UNAVAILABLE,
+	ErrEntityNotScanned:               ERR_ENTITY_NOT_SCANNED,
+	ErrEntityParentFolderMemberExists: ERR_ENTITY_PARENT_FOLDER_MEMBER_EXISTS,
+	ErrTooManyRequests:                ERR_TOO_MANY_REQUESTS,
+}
+
 // Specific kiteworks error object.
 type KWError struct {
-	flag    int64
-	message []string
+	flag        int64
+	message     []string
+	entries     []KWErrorEntry
+	status      int
+	method      string
+	path        string
+	retry_after time.Duration
+}
+
+// Single error reported by kiteworks.
+type KWErrorEntry struct {
+	Code     string          // kiteworks error code, ie.. ERR_ENTITY_NOT_FOUND.
+	Message  string          // Message provided with the error.
+	Status   int             // HTTP status code of the response.
+	Method   string          // Method of the request which failed.
+	Path     string          // Path of the request which failed.
+	Category KWErrorCategory // Categories the code belongs to.
+	flag     int64
+}
+
+// Returns Error String.
+func (e KWErrorEntry) Error() string {
+	return fmt.Sprintf("%s. (kiteworks:%s)", e.Message, e.Code)
+}
+
+// Matches sentinel errors and categories against the entry's code.
+func (e KWErrorEntry) Is(target error) bool {
+	if category, ok := target.(KWErrorCategory); ok {
+		return e.Category&category != 0
+	}
+	code, ok := target.(Error)
+	if !ok {
+		return false
+	}
+	if flag, ok := sentinel_flags[code]; ok && e.flag&flag != 0 {
+		return true
+	}
+	return strings.EqualFold(e.Code, string(code))
 }
 
 // Add a kiteworks error to APIError
 func (e *KWError) AddError(code, message string) {
 	code = strings.ToUpper(code)
+	kind := lookupKWError(code, e.status)
+
+	entry := KWErrorEntry{
+		Code:     code,
+		Message:  message,
+		Status:   e.status,
+		Method:   e.method,
+		Path:     e.path,
+		Category: kind.category,
+		flag:     kind.flag,
+	}
+	e.flag |= kind.flag
+	e.entries = append(e.entries, entry)
+	e.message = append(e.message, entry.Error())
+}
+
+// Returns the individual errors reported by kiteworks.
+func (e KWError) Entries() []KWErrorEntry {
+	return append([]KWErrorEntry(nil), e.entries...)
+}
 
-	switch code {
-	case "ERR_ENTITY_PARENT_FOLDER_MEMBER_EXISTS":
-		e.flag |= ERR_ENTITY_PARENT_FOLDER_MEMBER_EXISTS
-	case "ERR_ENTITY_NOT_SCANNED":
-		e.flag |= ERR_ENTITY_NOT_SCANNED
-	case "ERR_ENTITY_ROLE_IS_ASSIGNED":
-		e.flag |= ERR_ENTITY_ROLE_IS_ASSIGNED
-	case "ERR_ENTITY_EXISTS":
-		e.flag |= ERR_ENTITY_EXISTS
-	case "ERR_AUTH_UNAUTHORIZED":
-		e.flag |= ERR_AUTH_UNAUTHORIZED
-	case "unauthorized_client":
-		e.flag |= ERR_AUTH_UNAUTHORIZED
-	case "ERR_AUTH_PROFILE_CHANGED":
-		e.flag |= ERR_AUTH_PROFILE_CHANGED
-	case "ERR_ACCESS_USER":
-		e.flag |= ERR_ACCESS_USER
-	case "INVALID_GRANT":
-		e.flag |= ERR_INVALID_GRANT
-	case "ERR_ENTITY_DELETED_PERMANENTLY":
-		e.flag |= ERR_ENTITY_DELETED_PERMANENTLY
-	case "ERR_ENTITY_DELETED":
-		e.flag |= ERR_ENTITY_DELETED
-	case "ERR_ENTITY_NOT_FOUND":
-		e.flag |= ERR_ENTITY_NOT_FOUND
-	case "ERR_ENTITY_PARENT_FOLDER_DELETED":
-		e.flag |= ERR_ENTITY_PARENT_FOLDER_DELETED
-	case "ERR_REQUEST_METHOD_NOT_ALLOWED":
-		e.flag |= ERR_REQUEST_METHOD_NOT_ALLOWED
-	case "UNAVAILABLE":
-		e.flag |= UNAVAILABLE
-	default:
-		if strings.Contains(code, "ERR_INTERNAL_") {
-			e.flag |= ERR_INTERNAL_SERVER_ERROR
+// Returns entries as errors, allowing errors.Is and errors.As to inspect each of them.
+func (e *KWError) Unwrap() []error {
+	errs := make([]error, len(e.entries))
+	for i, v := range e.entries {
+		errs[i] = v
+	}
+	return errs
+}
+
+// Reports if any entry matches the sentinel error target.
+func (e *KWError) Is(target error) bool {
+	for _, v := range e.entries {
+		if v.Is(target) {
+			return true
 		}
 	}
-	e.message = append(e.message, fmt.Sprintf("%s. (kiteworks:%s)", message, code))
+	return false
+}
+
+// Sets target to the first entry when target is a *KWErrorEntry.
+func (e *KWError) As(target interface{}) bool {
+	if t, ok := target.(*KWErrorEntry); ok && len(e.entries) > 0 {
+		*t = e.entries[0]
+		return true
+	}
+	return false
 }
 
 // Returns Error String.
@@ -96,24 +185,37 @@ func (e KWError) Error() string {
 	return strings.Join(str, "\n")
 }
 
-// Check for specific error code.
+// Returns the combined categories of all entries.
+func (e KWError) Category() (category KWErrorCategory) {
+	for _, v := range e.entries {
+		category |= v.Category
+	}
+	return
+}
+
+// Returns HTTP status code of the response which generated the error, 0 if unknown.
+func (e KWError) StatusCode() int {
+	return e.status
+}
+
+// Returns delay requested by the server through the Retry-After header, 0 if none was provided.
+func (e KWError) RetryAfter() time.Duration {
+	return e.retry_after
+}
+
+// Check for specific error code, err may be wrapped.
 func KWAPIError(err error, input int64) bool {
-	if e, ok := err.(*KWError); !ok {
+	var e *KWError
+	if !errors.As(err, &e) {
 		return false
-	} else {
-		if e.flag&input != 0 {
-			return true
-		}
 	}
-	return false
+	return e.flag&input != 0
 }
 
-// Return true if error was generated by REST call.
+// Return true if error was generated by REST call, err may be wrapped.
 func IsKWError(err error) bool {
-	if _, ok := err.(*KWError); ok {
-		return true
-	}
-	return false
+	var e *KWError
+	return errors.As(err, &e)
 }
 
 // Create a new REST error.
@@ -123,7 +225,7 @@ func NewKWError() *KWError {
 	return e
 }
 
-// convert responses from kiteworks APIs to errors to return to callers.
+// convert responses from kiteworks APIs to errors to return to callers, the body of error responses is consumed and closed.
 func (K *KWAPI) respError(resp *http.Response) (err error) {
 	if resp == nil {
 		return
@@ -133,19 +235,13 @@ func (K *KWAPI) respError(resp *http.Response) (err error) {
 		return nil
 	}
 
-	var (
-		snoop_buffer bytes.Buffer
-		body         io.Reader
-	)
-
 	resp.Body = iotimeout.NewReadCloser(resp.Body, K.RequestTimeout)
 
-	if K.Snoop {
-		Snoop("<-- RESPONSE STATUS: %s", resp.Status)
-		body = io.TeeReader(resp.Body, &snoop_buffer)
-	} else {
-		body = resp.Body
-	}
+	// Drain and close the body so the connection can be reused.
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
 
 	// kiteworks API Error
 	type KiteErr struct {
@@ -157,32 +253,47 @@ func (K *KWAPI) respError(resp *http.Response) (err error) {
 		} `json:"errors"`
 	}
 
-	output, err := ioutil.ReadAll(body)
-
-	if K.Snoop {
-		snoop_request(&snoop_buffer)
-	}
-
+	output, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
 
 	var kite_err *KiteErr
 	json.Unmarshal(output, &kite_err)
+
+	e := NewKWError()
+	e.status = resp.StatusCode
+	e.retry_after = retryAfter(resp)
+	if resp.Request != nil {
+		e.method = resp.Request.Method
+		e.path = resp.Request.URL.Path
+	}
+
 	if kite_err != nil {
-		e := NewKWError()
 		for _, v := range kite_err.Errors {
 			e.AddError(v.Code, v.Message)
 		}
 		if kite_err.ErrorDesc != NONE {
 			e.AddError(kite_err.Error, kite_err.ErrorDesc)
 		}
-		return e
 	}
 
-	if resp.Status == "401 Unathorized" {
-		e := NewKWError()
-		e.AddError("ERR_AUTH_UNAUTHORIZED", "Unathorized Access Token")
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		if e.flag&ERR_TOO_MANY_REQUESTS == 0 {
+			e.AddError("ERR_TOO_MANY_REQUESTS", "Too many requests")
+		}
+	case http.StatusServiceUnavailable:
+		if e.flag&SERVICE_UNAVAILABLE == 0 {
+			e.AddError("SERVICE_UNAVAILABLE", "Service unavailable")
+		}
+	case http.StatusUnauthorized:
+		if len(e.message) == 0 {
+			e.AddError("ERR_AUTH_UNAUTHORIZED", "Unathorized Access Token")
+		}
+	}
+
+	if len(e.message) > 0 {
 		return e
 	}
 
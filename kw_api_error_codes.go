@@ -0,0 +1,196 @@
+package kwlib
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Broad classification of kiteworks error codes, usable as a target for errors.Is.
+type KWErrorCategory int64
+
+const (
+	CAT_RETRYABLE KWErrorCategory = 1 << iota
+	CAT_AUTH
+	CAT_NOT_FOUND
+	CAT_CONFLICT
+	CAT_PERMISSION
+	CAT_VALIDATION
+	CAT_QUOTA
+	CAT_LICENSE
+	CAT_DLP
+	CAT_ANTIVIRUS
+)
+
+var kw_category_names = []string{
+	"retryable",
+	"auth",
+	"not found",
+	"conflict",
+	"permission",
+	"validation",
+	"quota",
+	"license",
+	"dlp",
+	"antivirus",
+}
+
+// Returns names of categories set.
+func (c KWErrorCategory) Error() string {
+	var names []string
+	for i, name := range kw_category_names {
+		if c&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "uncategorized"
+	}
+	return strings.Join(names, "|")
+}
+
+// Flag and category assigned to a kiteworks error code.
+type kw_error_code struct {
+	flag     int64
+	category KWErrorCategory
+}
+
+// Categories applied to codes not found in the registry, matched by prefix.
+type kw_error_prefix struct {
+	prefix string
+	kw_error_code
+}
+
+var (
+	kw_error_lock  sync.RWMutex
+	kw_error_codes = map[string]kw_error_code{
+		// Authentication
+		"ERR_AUTH_UNAUTHORIZED":    {ERR_AUTH_UNAUTHORIZED, CAT_AUTH},
+		"ERR_AUTH_PROFILE_CHANGED": {ERR_AUTH_PROFILE_CHANGED, CAT_AUTH},
+		"INVALID_GRANT":            {ERR_INVALID_GRANT, CAT_AUTH},
+		"UNAUTHORIZED_CLIENT":      {ERR_AUTH_UNAUTHORIZED, CAT_AUTH},
+		"INVALID_CLIENT":           {0, CAT_AUTH},
+		"INVALID_TOKEN":            {ERR_AUTH_UNAUTHORIZED, CAT_AUTH},
+		"ACCESS_DENIED":            {0, CAT_AUTH},
+		"INVALID_SCOPE":            {0, CAT_AUTH},
+		"INVALID_REQUEST":          {0, CAT_VALIDATION},
+		"UNSUPPORTED_GRANT_TYPE":   {0, CAT_VALIDATION},
+
+		// Access
+		"ERR_ACCESS_USER":   {ERR_ACCESS_USER, CAT_PERMISSION},
+		"ERR_ACCESS_ADMIN":  {0, CAT_PERMISSION},
+		"ERR_ACCESS_FOLDER": {0, CAT_PERMISSION},
+		"ERR_ACCESS_FILE":   {0, CAT_PERMISSION},
+		"ERR_ACCESS_ROLE":   {0, CAT_PERMISSION},
+
+		// Missing entities
+		"ERR_ENTITY_NOT_FOUND":             {ERR_ENTITY_NOT_FOUND, CAT_NOT_FOUND},
+		"ERR_ENTITY_DELETED":               {ERR_ENTITY_DELETED, CAT_NOT_FOUND},
+		"ERR_ENTITY_DELETED_PERMANENTLY":   {ERR_ENTITY_DELETED_PERMANENTLY, CAT_NOT_FOUND},
+		"ERR_ENTITY_PARENT_FOLDER_DELETED": {ERR_ENTITY_PARENT_FOLDER_DELETED, CAT_NOT_FOUND},
+		"ERR_REQUEST_NOT_FOUND":            {0, CAT_NOT_FOUND},
+
+		// Conflicts
+		"ERR_ENTITY_EXISTS":                      {ERR_ENTITY_EXISTS, CAT_CONFLICT},
+		"ERR_ENTITY_ROLE_IS_ASSIGNED":            {ERR_ENTITY_ROLE_IS_ASSIGNED, CAT_CONFLICT},
+		"ERR_ENTITY_PARENT_FOLDER_MEMBER_EXISTS": {ERR_ENTITY_PARENT_FOLDER_MEMBER_EXISTS, CAT_CONFLICT},
+		"ERR_ENTITY_IS_LOCKED":                   {0, CAT_CONFLICT},
+		"ERR_ENTITY_LOCKED":                      {0, CAT_CONFLICT},
+
+		// Validation
+		"ERR_REQUEST_METHOD_NOT_ALLOWED": {ERR_REQUEST_METHOD_NOT_ALLOWED, CAT_VALIDATION},
+		"ERR_REQUEST_INVALID_PARAMETER":  {0, CAT_VALIDATION},
+		"ERR_INPUT_INVALID":              {0, CAT_VALIDATION},
+		"ERR_INPUT_REQUIRED":             {0, CAT_VALIDATION},
+		"ERR_ENTITY_NAME_INVALID":        {0, CAT_VALIDATION},
+		"ERR_ENTITY_FILE_TYPE_BLOCKED":   {0, CAT_VALIDATION},
+		"ERR_ENTITY_FILE_TOO_LARGE":      {0, CAT_VALIDATION | CAT_QUOTA},
+
+		// Quota
+		"ERR_ENTITY_QUOTA_EXCEEDED":  {0, CAT_QUOTA},
+		"ERR_STORAGE_QUOTA_EXCEEDED": {0, CAT_QUOTA},
+		"ERR_USER_QUOTA_EXCEEDED":    {0, CAT_QUOTA},
+
+		// License
+		"ERR_LICENSE_EXPIRED":        {0, CAT_LICENSE},
+		"ERR_LICENSE_INVALID":        {0, CAT_LICENSE},
+		"ERR_LICENSE_LIMIT_EXCEEDED": {0, CAT_LICENSE | CAT_QUOTA},
+
+		// Data loss prevention
+		"ERR_ENTITY_DLP_LOCKED":   {0, CAT_DLP},
+		"ERR_ENTITY_DLP_BLOCKED":  {0, CAT_DLP},
+		"ERR_ENTITY_DLP_PENDING":  {0, CAT_DLP | CAT_RETRYABLE},
+		"ERR_ENTITY_DLP_REJECTED": {0, CAT_DLP},
+
+		// Antivirus
+		"ERR_ENTITY_NOT_SCANNED":  {ERR_ENTITY_NOT_SCANNED, CAT_ANTIVIRUS},
+		"ERR_ENTITY_VIRUS_FOUND":  {0, CAT_ANTIVIRUS},
+		"ERR_ENTITY_INFECTED":     {0, CAT_ANTIVIRUS},
+		"ERR_ENTITY_SCAN_PENDING": {0, CAT_ANTIVIRUS | CAT_RETRYABLE},
+
+		// Availability
+		"ERR_INTERNAL_SERVER_ERROR": {ERR_INTERNAL_SERVER_ERROR, CAT_RETRYABLE},
+		"UNAVAILABLE":               {UNAVAILABLE, CAT_RETRYABLE},
+		"SERVICE_UNAVAILABLE":       {SERVICE_UNAVAILABLE, CAT_RETRYABLE},
+		"ERR_TOO_MANY_REQUESTS":     {ERR_TOO_MANY_REQUESTS, CAT_RETRYABLE},
+	}
+	kw_error_prefixes = []kw_error_prefix{
+		{"ERR_INTERNAL_", kw_error_code{ERR_INTERNAL_SERVER_ERROR, CAT_RETRYABLE}},
+		{"ERR_AUTH_", kw_error_code{0, CAT_AUTH}},
+		{"ERR_ACCESS_", kw_error_code{0, CAT_PERMISSION}},
+		{"ERR_INPUT_", kw_error_code{0, CAT_VALIDATION}},
+		{"ERR_LICENSE_", kw_error_code{0, CAT_LICENSE}},
+		{"ERR_ENTITY_DLP_", kw_error_code{0, CAT_DLP}},
+		{"ERR_DLP_", kw_error_code{0, CAT_DLP}},
+		{"ERR_ENTITY_VIRUS_", kw_error_code{0, CAT_ANTIVIRUS}},
+		{"ERR_AV_", kw_error_code{0, CAT_ANTIVIRUS}},
+	}
+)
+
+// Registers kiteworks error code with category, replacing any existing categories for the code.
+func RegisterKWError(code string, category KWErrorCategory) {
+	code = strings.ToUpper(code)
+	kw_error_lock.Lock()
+	defer kw_error_lock.Unlock()
+	existing := kw_error_codes[code]
+	kw_error_codes[code] = kw_error_code{existing.flag, category}
+}
+
+// Registers category for all unregistered kiteworks error codes beginning with prefix.
+func RegisterKWErrorPrefix(prefix string, category KWErrorCategory) {
+	prefix = strings.ToUpper(prefix)
+	kw_error_lock.Lock()
+	defer kw_error_lock.Unlock()
+	kw_error_prefixes = append([]kw_error_prefix{{prefix, kw_error_code{0, category}}}, kw_error_prefixes...)
+}
+
+// Looks up flag and category for code, falling back to prefix rules and then the HTTP status.
+func lookupKWError(code string, status int) kw_error_code {
+	kw_error_lock.RLock()
+	defer kw_error_lock.RUnlock()
+
+	if v, ok := kw_error_codes[code]; ok {
+		return v
+	}
+	for _, v := range kw_error_prefixes {
+		if strings.HasPrefix(code, v.prefix) {
+			return v.kw_error_code
+		}
+	}
+
+	switch {
+	case status == http.StatusUnauthorized:
+		return kw_error_code{0, CAT_AUTH}
+	case status == http.StatusForbidden:
+		return kw_error_code{0, CAT_PERMISSION}
+	case status == http.StatusNotFound:
+		return kw_error_code{0, CAT_NOT_FOUND}
+	case status == http.StatusConflict:
+		return kw_error_code{0, CAT_CONFLICT}
+	case status == http.StatusBadRequest || status == http.StatusUnprocessableEntity:
+		return kw_error_code{0, CAT_VALIDATION}
+	case status == http.StatusTooManyRequests || status >= 500:
+		return kw_error_code{0, CAT_RETRYABLE}
+	}
+	return kw_error_code{}
+}
@@ -0,0 +1,118 @@
+package kwlib_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cmcoffee/go-kwlib"
+)
+
+func TestErrorsIs(t *testing.T) {
+	_, s, _ := testSession(t)
+
+	_, err := s.Folders().Get(99999)
+	wrapped := fmt.Errorf("loading folder: %w", err)
+
+	if !errors.Is(wrapped, kwlib.ErrEntityNotFound) {
+		t.Fatalf("%v is not ErrEntityNotFound", wrapped)
+	}
+	if errors.Is(wrapped, kwlib.ErrEntityExists) {
+		t.Fatalf("%v is ErrEntityExists", wrapped)
+	}
+	if !kwlib.KWAPIError(wrapped, kwlib.ERR_ENTITY_NOT_FOUND) || !kwlib.IsKWError(wrapped) {
+		t.Fatal("KWAPIError does not see through wrapping")
+	}
+
+	var entry kwlib.KWErrorEntry
+	if !errors.As(wrapped, &entry) {
+		t.Fatal("no KWErrorEntry in error")
+	}
+	if entry.Status != 404 || entry.Method != "GET" || !strings.HasSuffix(entry.Path, "/rest/folders/99999") {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	var kw_err *kwlib.KWError
+	if !errors.As(wrapped, &kw_err) || kw_err.StatusCode() != 404 {
+		t.Fatal("no KWError with status 404 in error")
+	}
+}
+
+func TestErrorCategories(t *testing.T) {
+	srv, s, user := testSession(t)
+	s.Retries = 1
+	s.RetryPolicy = kwlib.ConstantRetry(0)
+
+	// Returns the category of the error from code.
+	category := func(status int, code string) kwlib.KWErrorCategory {
+		t.Helper()
+		srv.Fail("GET", "/rest/folders/", status, code, 1)
+		_, err := s.Folders().Get(user.BaseDirID)
+		var kw_err *kwlib.KWError
+		if !errors.As(err, &kw_err) || !errors.Is(err, kwlib.Error(code)) {
+			t.Fatalf("%s: expected a KWError, got %v", code, err)
+		}
+		return kw_err.Category()
+	}
+
+	_, err := s.Folders().Get(99999)
+	var kw_err *kwlib.KWError
+	if !errors.As(err, &kw_err) || !errors.Is(err, kwlib.CAT_NOT_FOUND) || errors.Is(err, kwlib.CAT_RETRYABLE) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	for _, test := range []struct {
+		status   int
+		code     string
+		category kwlib.KWErrorCategory
+	}{
+		{403, "ERR_ACCESS_FOLDER", kwlib.CAT_PERMISSION},
+		{403, "ERR_STORAGE_QUOTA_EXCEEDED", kwlib.CAT_QUOTA},
+		{403, "ERR_LICENSE_EXPIRED", kwlib.CAT_LICENSE},
+		{403, "ERR_LICENSE_LIMIT_EXCEEDED", kwlib.CAT_LICENSE | kwlib.CAT_QUOTA},
+		{403, "ERR_ENTITY_DLP_BLOCKED", kwlib.CAT_DLP},
+		{403, "ERR_ENTITY_VIRUS_FOUND", kwlib.CAT_ANTIVIRUS},
+		{400, "ERR_ENTITY_FILE_TOO_LARGE", kwlib.CAT_VALIDATION | kwlib.CAT_QUOTA},
+		// Unlisted codes fall back to prefix rules, then the HTTP status.
+		{403, "ERR_LICENSE_SEATS", kwlib.CAT_LICENSE},
+		{400, "ERR_KWLIB_TEST_UNKNOWN", kwlib.CAT_VALIDATION},
+	} {
+		if got := category(test.status, test.code); got != test.category {
+			t.Errorf("%s categorized %s, expected %s", test.code, got, test.category)
+		}
+	}
+	if kwlib.CAT_QUOTA.Error() != "quota" {
+		t.Errorf("CAT_QUOTA is named %q", kwlib.CAT_QUOTA.Error())
+	}
+
+	// Retryable codes, listed and of the ERR_INTERNAL_ family, are retried.
+	for _, code := range []string{"ERR_ENTITY_DLP_PENDING", "ERR_INTERNAL_DATABASE"} {
+		srv.Fail("GET", "/rest/folders/", 400, code, 1)
+		if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+			t.Fatalf("%s was not retried: %s", code, err)
+		}
+	}
+}
+
+func TestRegisterKWError(t *testing.T) {
+	t.Cleanup(kwlib.SaveKWErrors())
+
+	srv, s, user := testSession(t)
+	s.Retries = 1
+	s.RetryPolicy = kwlib.ConstantRetry(0)
+
+	kwlib.RegisterKWError("err_kwlib_test_pending", kwlib.CAT_DLP|kwlib.CAT_RETRYABLE)
+	srv.Fail("GET", "/rest/folders/", 400, "ERR_KWLIB_TEST_PENDING", 1)
+	if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+		t.Fatalf("registered retryable code was not retried: %s", err)
+	}
+
+	kwlib.RegisterKWErrorPrefix("ERR_KWLIB_QUOTA_", kwlib.CAT_QUOTA)
+	srv.Fail("GET", "/rest/folders/", 400, "ERR_KWLIB_QUOTA_FILES", 1)
+	_, err := s.Folders().Get(user.BaseDirID)
+	var kw_err *kwlib.KWError
+	if !errors.As(err, &kw_err) || kw_err.Category() != kwlib.CAT_QUOTA {
+		t.Fatalf("expected a quota error, got %v", err)
+	}
+}
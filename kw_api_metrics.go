@@ -0,0 +1,280 @@
+package kwlib
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric names reported by KWAPI.
+const (
+	METRIC_REQUESTS        = "kwlib_requests_total"           // Counter of requests, labeled by method, path and status.
+	METRIC_REQUEST_SECONDS = "kwlib_request_duration_seconds" // Histogram of request latency, labeled by method and path.
+	METRIC_RETRIES         = "kwlib_retries_total"            // Counter of retried attempts, labeled by method and path.
+	METRIC_TOKEN_REQUESTS  = "kwlib_token_requests_total"     // Counter of token requests, labeled by grant and result.
+	METRIC_TRANSFER_BYTES  = "kwlib_transfer_bytes_total"     // Counter of file bytes moved, labeled by direction.
+)
+
+// Labels attached to a metric.
+type Labels map[string]string
+
+// Metrics receives counters and observations from KWAPI, set KWAPI.Metrics to enable.
+// Methods may be called concurrently from multiple goroutines.
+type Metrics interface {
+	Add(name string, labels Labels, delta float64)     // Adds delta to a counter.
+	Observe(name string, labels Labels, value float64) // Records value in a histogram.
+}
+
+// Adds delta to counter when metrics are enabled.
+func (K *KWAPI) metricAdd(name string, labels Labels, delta float64) {
+	if K.Metrics != nil {
+		K.Metrics.Add(name, labels, delta)
+	}
+}
+
+// Records a completed request.
+func (K *KWAPI) metricRequest(method, path string, resp *http.Response, duration time.Duration) {
+	if K.Metrics == nil {
+		return
+	}
+	status := "error"
+	if resp != nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	path = pathTemplate(path)
+	K.Metrics.Add(METRIC_REQUESTS, Labels{"method": method, "path": path, "status": status}, 1)
+	K.Metrics.Observe(METRIC_REQUEST_SECONDS, Labels{"method": method, "path": path}, duration.Seconds())
+}
+
+// Replaces IDs in path with :id, so requests for different entities share labels.
+func pathTemplate(path string) string {
+	segments := strings.Split(path, "/")
+	for i, v := range segments {
+		if v == NONE {
+			continue
+		}
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			segments[i] = ":id"
+			continue
+		}
+		if len(v) >= 16 && strings.Trim(strings.ToLower(v), "0123456789abcdef-") == NONE {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Default histogram buckets, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// In memory Metrics implementation, exported through expvar or Prometheus text format.
+type MetricsRegistry struct {
+	mutex      sync.Mutex
+	buckets    []float64
+	counters   map[string]*metric_counter
+	histograms map[string]*metric_histogram
+}
+
+type metric_counter struct {
+	name   string
+	labels string
+	value  float64
+}
+
+type metric_histogram struct {
+	name   string
+	labels string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Creates a MetricsRegistry, histograms use buckets or DefaultBuckets when none are provided.
+func NewMetricsRegistry(buckets ...float64) *MetricsRegistry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &MetricsRegistry{
+		buckets:    buckets,
+		counters:   make(map[string]*metric_counter),
+		histograms: make(map[string]*metric_histogram),
+	}
+}
+
+// Formats labels in Prometheus style, sorted by name.
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return NONE
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%s", k, strconv.Quote(labels[k]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Adds delta to a counter.
+func (M *MetricsRegistry) Add(name string, labels Labels, delta float64) {
+	l := formatLabels(labels)
+	M.mutex.Lock()
+	defer M.mutex.Unlock()
+	c, ok := M.counters[name+"{"+l+"}"]
+	if !ok {
+		c = &metric_counter{name: name, labels: l}
+		M.counters[name+"{"+l+"}"] = c
+	}
+	c.value += delta
+}
+
+// Records value in a histogram.
+func (M *MetricsRegistry) Observe(name string, labels Labels, value float64) {
+	l := formatLabels(labels)
+	M.mutex.Lock()
+	defer M.mutex.Unlock()
+	h, ok := M.histograms[name+"{"+l+"}"]
+	if !ok {
+		h = &metric_histogram{name: name, labels: l, counts: make([]uint64, len(M.buckets))}
+		M.histograms[name+"{"+l+"}"] = h
+	}
+	for i, b := range M.buckets {
+		if value <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Returns current value of counter, 0 if it has not been recorded.
+func (M *MetricsRegistry) Counter(name string, labels Labels) float64 {
+	M.mutex.Lock()
+	defer M.mutex.Unlock()
+	if c, ok := M.counters[name+"{"+formatLabels(labels)+"}"]; ok {
+		return c.value
+	}
+	return 0
+}
+
+// Joins series name with labels and an optional extra label.
+func series(name, labels, extra string) string {
+	switch {
+	case labels == NONE && extra == NONE:
+		return name
+	case labels == NONE:
+		return name + "{" + extra + "}"
+	case extra == NONE:
+		return name + "{" + labels + "}"
+	}
+	return name + "{" + labels + "," + extra + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Writes all metrics in Prometheus text exposition format.
+func (M *MetricsRegistry) WritePrometheus(w io.Writer) {
+	M.mutex.Lock()
+	defer M.mutex.Unlock()
+
+	counters := make([]*metric_counter, 0, len(M.counters))
+	for _, c := range M.counters {
+		counters = append(counters, c)
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].name != counters[j].name {
+			return counters[i].name < counters[j].name
+		}
+		return counters[i].labels < counters[j].labels
+	})
+
+	var last string
+	for _, c := range counters {
+		if c.name != last {
+			fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+			last = c.name
+		}
+		fmt.Fprintf(w, "%s %s\n", series(c.name, c.labels, NONE), formatFloat(c.value))
+	}
+
+	histograms := make([]*metric_histogram, 0, len(M.histograms))
+	for _, h := range M.histograms {
+		histograms = append(histograms, h)
+	}
+	sort.Slice(histograms, func(i, j int) bool {
+		if histograms[i].name != histograms[j].name {
+			return histograms[i].name < histograms[j].name
+		}
+		return histograms[i].labels < histograms[j].labels
+	})
+
+	last = NONE
+	for _, h := range histograms {
+		if h.name != last {
+			fmt.Fprintf(w, "# TYPE %s histogram\n", h.name)
+			last = h.name
+		}
+		for i, b := range M.buckets {
+			fmt.Fprintf(w, "%s %d\n", series(h.name+"_bucket", h.labels, "le="+strconv.Quote(formatFloat(b))), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s %d\n", series(h.name+"_bucket", h.labels, `le="+Inf"`), h.count)
+		fmt.Fprintf(w, "%s %s\n", series(h.name+"_sum", h.labels, NONE), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s %d\n", series(h.name+"_count", h.labels, NONE), h.count)
+	}
+}
+
+// Returns http.Handler serving metrics in Prometheus text format.
+func (M *MetricsRegistry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buffer bytes.Buffer
+		M.WritePrometheus(&buffer)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buffer.Bytes())
+	})
+}
+
+// Returns a snapshot of all metrics keyed by series.
+func (M *MetricsRegistry) Snapshot() map[string]interface{} {
+	M.mutex.Lock()
+	defer M.mutex.Unlock()
+
+	output := make(map[string]interface{}, len(M.counters)+len(M.histograms))
+	for _, c := range M.counters {
+		output[series(c.name, c.labels, NONE)] = c.value
+	}
+	for _, h := range M.histograms {
+		buckets := make(map[string]uint64, len(M.buckets))
+		for i, b := range M.buckets {
+			buckets[formatFloat(b)] = h.counts[i]
+		}
+		output[series(h.name, h.labels, NONE)] = map[string]interface{}{
+			"count":   h.count,
+			"sum":     h.sum,
+			"buckets": buckets,
+		}
+	}
+	return output
+}
+
+// Publishes metrics to expvar under name, which must not already be published.
+func (M *MetricsRegistry) Expvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return M.Snapshot() }))
+}
@@ -0,0 +1,86 @@
+package kwlib_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cmcoffee/go-kwlib"
+)
+
+func TestMetrics(t *testing.T) {
+	srv, s, user := testSession(t)
+	metrics := kwlib.NewMetricsRegistry()
+	s.Metrics = metrics
+	s.RetryPolicy = kwlib.ConstantRetry(0)
+
+	srv.Fail("GET", "/rest/folders/", 503, "SERVICE_UNAVAILABLE", 1)
+	if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+
+	file := srv.AddFile(user.BaseDirID, "download.txt", []byte("hello world"))
+	if err := s.DownloadFile(file.ID, filepath.Join(t.TempDir(), "download.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	upload_id, err := s.NewUpload(user.BaseDirID, "upload.txt", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(tempFile(t, "upload.txt", []byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := s.Upload("upload.txt", upload_id, f); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		labels kwlib.Labels
+		value  float64
+	}{
+		{kwlib.METRIC_REQUESTS, kwlib.Labels{"method": "GET", "path": "/rest/folders/:id", "status": "503"}, 1},
+		{kwlib.METRIC_REQUESTS, kwlib.Labels{"method": "GET", "path": "/rest/folders/:id", "status": "200"}, 1},
+		{kwlib.METRIC_RETRIES, kwlib.Labels{"method": "GET", "path": "/rest/folders/:id"}, 1},
+		{kwlib.METRIC_TOKEN_REQUESTS, kwlib.Labels{"grant": "authorization_code", "result": "success"}, 0},
+		{kwlib.METRIC_TRANSFER_BYTES, kwlib.Labels{"direction": "download"}, 11},
+		{kwlib.METRIC_TRANSFER_BYTES, kwlib.Labels{"direction": "upload"}, 5},
+	} {
+		if v := metrics.Counter(test.name, test.labels); v != test.value {
+			t.Errorf("%s%v = %v, expected %v", test.name, test.labels, v, test.value)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`kwlib_retries_total{method="GET",path="/rest/folders/:id"} 1`,
+		`kwlib_request_duration_seconds_count{method="GET",path="/rest/folders/:id"} 2`,
+		`kwlib_request_duration_seconds_bucket{method="GET",path="/rest/folders/:id",le="+Inf"} 2`,
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("exposition is missing %s:\n%s", line, rec.Body.String())
+		}
+	}
+
+	var output bytes.Buffer
+	metrics.WritePrometheus(&output)
+	if output.String() != rec.Body.String() {
+		t.Error("WritePrometheus and Handler disagree")
+	}
+
+	// Expired tokens are counted as they are renewed.
+	srv.ExpireTokens()
+	if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+	if v := metrics.Counter(kwlib.METRIC_TOKEN_REQUESTS, kwlib.Labels{"grant": "authorization_code", "result": "success"}); v != 1 {
+		t.Errorf("%s = %v after renewal, expected 1", kwlib.METRIC_TOKEN_REQUESTS, v)
+	}
+}
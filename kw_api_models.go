@@ -0,0 +1,99 @@
+package kwlib
+
+import (
+	"time"
+)
+
+// kiteworks Folder.
+type KWFolder struct {
+	ID                int    `json:"id"`
+	Name              string `json:"name"`
+	Description       string `json:"description"`
+	ParentID          int    `json:"parentId"`
+	UserID            int    `json:"userId"`
+	Type              string `json:"type"`
+	Path              string `json:"path"`
+	Created           string `json:"created"`
+	Modified          string `json:"modified"`
+	Deleted           bool   `json:"deleted"`
+	PermDeleted       bool   `json:"permDeleted"`
+	Secure            bool   `json:"secure"`
+	Syncable          bool   `json:"syncable"`
+	TotalFilesCount   int    `json:"totalFilesCount"`
+	TotalFoldersCount int    `json:"totalFoldersCount"`
+	CurrentUserRole   KWRole `json:"currentUserRole"`
+}
+
+// kiteworks File.
+type KWFile struct {
+	ID             int    `json:"id"`
+	Name           string `json:"name"`
+	ParentID       int    `json:"parentId"`
+	UserID         int    `json:"userId"`
+	Type           string `json:"type"`
+	Size           int64  `json:"size"`
+	Mime           string `json:"mime"`
+	Created        string `json:"created"`
+	Modified       string `json:"modified"`
+	ClientCreated  string `json:"clientCreated"`
+	ClientModified string `json:"clientModified"`
+	Fingerprint    string `json:"fingerprint"`
+	Deleted        bool   `json:"deleted"`
+	PermDeleted    bool   `json:"permDeleted"`
+	Locked         bool   `json:"locked"`
+	LockUser       int    `json:"lockUser"`
+	AVStatus       string `json:"avStatus"`
+	DLPStatus      string `json:"dlpStatus"`
+}
+
+// Returns the client modified time of the file, falls back to the server modified time.
+func (f KWFile) ModifiedTime() (time.Time, error) {
+	if f.ClientModified != NONE {
+		return ReadKWTime(f.ClientModified)
+	}
+	return ReadKWTime(f.Modified)
+}
+
+// kiteworks User.
+type KWUser struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	BaseDirID  int    `json:"basedirId"`
+	MyDirID    int    `json:"mydirId"`
+	SyncDirID  int    `json:"syncdirId"`
+	UserTypeID int    `json:"userTypeId"`
+	Created    string `json:"created"`
+	Active     bool   `json:"active"`
+	Verified   bool   `json:"verified"`
+	Suspended  bool   `json:"suspended"`
+	Deleted    bool   `json:"deleted"`
+}
+
+// kiteworks Role.
+type KWRole struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Rank       int    `json:"rank"`
+	Modifiable bool   `json:"modifiable"`
+}
+
+// kiteworks folder Member.
+type KWMember struct {
+	ObjectID int    `json:"objectId"`
+	RoleID   int    `json:"roleId"`
+	UserID   int    `json:"userId"`
+	User     KWUser `json:"user"`
+	Role     KWRole `json:"role"`
+}
+
+// kiteworks Upload record.
+type KWUpload struct {
+	ID             int    `json:"id"`
+	TotalSize      int64  `json:"totalSize"`
+	TotalChunks    int64  `json:"totalChunks"`
+	UploadedSize   int64  `json:"uploadedSize"`
+	UploadedChunks int64  `json:"uploadedChunks"`
+	Finished       bool   `json:"finished"`
+	URI            string `json:"uri"`
+}
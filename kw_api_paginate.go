@@ -0,0 +1,160 @@
+package kwlib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Iterates over the elements of a kiteworks list endpoint, fetching pages as they are needed.
+type Paginator struct {
+	session  KWSession
+	ctx      context.Context
+	cancel   context.CancelFunc
+	req      APIRequest
+	params   []interface{}
+	limit    int
+	offset   int
+	total    int
+	prefetch bool
+	page     []json.RawMessage
+	index    int
+	last     bool
+	pending  chan kw_page
+	err      error
+}
+
+// Single page of results.
+type kw_page struct {
+	data  []json.RawMessage
+	total int
+	err   error
+}
+
+// Returns a Paginator for req, fetching page_size elements at a time.
+// Close is required when finished with the Paginator, the context it holds is only released on its own once the last page has been read.
+func (s KWSession) Paginate(req APIRequest, page_size int) *Paginator {
+	return s.PaginateContext(context.Background(), req, page_size)
+}
+
+// Paginate bound to ctx, fetching stops once ctx is done.
+// As with Paginate, Close is required when finished with the Paginator.
+func (s KWSession) PaginateContext(ctx context.Context, req APIRequest, page_size int) *Paginator {
+	if page_size <= 0 {
+		page_size = 100
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &Paginator{
+		session: s,
+		ctx:     ctx,
+		cancel:  cancel,
+		req:     req,
+		params:  req.Params,
+		limit:   page_size,
+		total:   -1,
+		index:   -1,
+	}
+}
+
+// Fetch the following page in the background while the current page is consumed.
+func (P *Paginator) Prefetch() *Paginator {
+	P.prefetch = true
+	return P
+}
+
+// Fetches page at offset.
+func (P *Paginator) fetch(offset int) (page kw_page) {
+	page.data, page.total, page.err = P.session.dataPage(P.ctx, P.req, P.params, offset, P.limit)
+	return
+}
+
+// Starts fetching the page at offset in the background.
+func (P *Paginator) fetchAsync(offset int) {
+	P.pending = make(chan kw_page, 1)
+	go func(pending chan kw_page) {
+		pending <- P.fetch(offset)
+	}(P.pending)
+}
+
+// Loads the next page, returns false when there are none left.
+func (P *Paginator) nextPage() bool {
+	if P.last || P.err != nil {
+		return false
+	}
+
+	var page kw_page
+	if P.pending != nil {
+		page = <-P.pending
+		P.pending = nil
+	} else {
+		page = P.fetch(P.offset)
+	}
+	if page.err != nil {
+		P.err = page.err
+		P.cancel()
+		return false
+	}
+
+	P.page = page.data
+	P.index = -1
+	P.offset = P.offset + len(page.data)
+	if page.total >= 0 {
+		P.total = page.total
+	}
+
+	if len(page.data) < P.limit || (P.total >= 0 && P.offset >= P.total) {
+		// Nothing left to fetch, release the context.
+		P.last = true
+		P.cancel()
+	} else if P.prefetch {
+		P.fetchAsync(P.offset)
+	}
+
+	return len(P.page) > 0
+}
+
+// Advances to the next element, returns false when finished or an error occurs.
+func (P *Paginator) Next() bool {
+	if P.err != nil {
+		return false
+	}
+	if P.index+1 < len(P.page) {
+		P.index++
+		return true
+	}
+	if !P.nextPage() {
+		P.page = nil
+		return false
+	}
+	P.index++
+	return true
+}
+
+// Decodes the current element into output.
+func (P *Paginator) Decode(output interface{}) error {
+	if P.index < 0 || P.index >= len(P.page) {
+		return fmt.Errorf("Paginator has no current element, call Next first.")
+	}
+	return json.Unmarshal(P.page[P.index], output)
+}
+
+// Returns the total number of elements reported by kiteworks, or -1 if unknown.
+func (P *Paginator) Total() int {
+	return P.total
+}
+
+// Returns the error which stopped iteration, if any.
+func (P *Paginator) Err() error {
+	return P.err
+}
+
+// Stops iteration and any outstanding prefetch.
+func (P *Paginator) Close() {
+	P.cancel()
+	if P.pending != nil {
+		<-P.pending
+		P.pending = nil
+	}
+	P.last = true
+	P.page = nil
+}
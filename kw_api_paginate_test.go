@@ -0,0 +1,72 @@
+package kwlib_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cmcoffee/go-kwlib"
+)
+
+func TestPaginate(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	for i := 0; i < 25; i++ {
+		srv.AddFolder(user.BaseDirID, fmt.Sprintf("folder%02d", i))
+	}
+
+	req := kwlib.APIRequest{
+		Method: "GET",
+		Path:   kwlib.SetPath("/rest/folders/%d/folders", user.BaseDirID),
+		Params: kwlib.SetParams(kwlib.Query{"deleted": false}),
+	}
+
+	for _, prefetch := range []bool{false, true} {
+		p := s.Paginate(req, 10)
+		if prefetch {
+			p.Prefetch()
+		}
+
+		var names []string
+		for p.Next() {
+			var folder kwlib.KWFolder
+			if err := p.Decode(&folder); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, folder.Name)
+		}
+		if err := p.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 25 || names[0] != "folder00" || names[24] != "folder24" || p.Total() != 25 {
+			t.Fatalf("prefetch %t: iterated %v of %d", prefetch, names, p.Total())
+		}
+		if !p.Released() {
+			t.Fatalf("prefetch %t: context held after the last page", prefetch)
+		}
+		p.Close()
+	}
+
+	// Errors stop iteration and release the context.
+	p := s.Paginate(kwlib.APIRequest{Method: "GET", Path: "/rest/folders/99999/folders"}, 10).Prefetch()
+	if p.Next() || !kwlib.KWAPIError(p.Err(), kwlib.ERR_ENTITY_NOT_FOUND) {
+		t.Fatalf("expected ERR_ENTITY_NOT_FOUND, got %v", p.Err())
+	}
+	if !p.Released() {
+		t.Fatal("context held after an error")
+	}
+	p.Close()
+
+	// Closing part way through stops iteration.
+	p = s.PaginateContext(context.Background(), req, 10).Prefetch()
+	if !p.Next() {
+		t.Fatal(p.Err())
+	}
+	if p.Released() {
+		t.Fatal("context released before the last page")
+	}
+	p.Close()
+	if !p.Released() || p.Next() {
+		t.Fatal("iteration continued after Close")
+	}
+}
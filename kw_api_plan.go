@@ -0,0 +1,465 @@
+package kwlib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Mutating calls recorded by a session in plan mode, for review before being replayed for real.
+type Plan struct {
+	mutex sync.Mutex
+	steps []PlanStep
+}
+
+// Single operation recorded in a Plan.
+type PlanStep struct {
+	Username string                 `json:"username,omitempty"`
+	Method   string                 `json:"method"` // HTTP method, or UPLOAD for file content.
+	Path     string                 `json:"path,omitempty"`
+	APIVer   int                    `json:"api_version,omitempty"`
+	Query    map[string]string      `json:"query,omitempty"`
+	Form     map[string]string      `json:"form,omitempty"`
+	JSON     map[string]interface{} `json:"json,omitempty"`
+	Filename string                 `json:"filename,omitempty"`  // Name of the uploaded file.
+	UploadID int                    `json:"upload_id,omitempty"` // Upload the file content is sent to.
+	Source   string                 `json:"source,omitempty"`    // Local file read by the upload, when known.
+	ID       int                    `json:"id,omitempty"`        // Placeholder standing in for the ID of the entity the step returns.
+	Refs     []string               `json:"refs,omitempty"`      // Parts of the step holding placeholders of earlier steps, ie.. path, query.<key>, json.<key> or upload_id.
+	source   ReadSeekCloser
+}
+
+// Returns the method and path of the step, or the filename for uploads.
+func (p PlanStep) String() string {
+	if p.Method == "UPLOAD" {
+		return p.Method + " " + p.Filename
+	}
+	return p.Method + " " + p.Path
+}
+
+// Returns a session which records mutating calls and uploads to plan instead of sending them.
+// GET requests are still sent, unless they refer to a placeholder ID, in which case they return an empty result.
+func (s KWSession) PlanMode(plan *Plan) KWSession {
+	s.plan = plan
+	return s
+}
+
+// Appends step, returning its placeholder ID, which is negative so it can not collide with a real ID.
+func (P *Plan) add(step PlanStep, returns_id bool) int {
+	P.mutex.Lock()
+	defer P.mutex.Unlock()
+	id := -(len(P.steps) + 1)
+	if returns_id {
+		step.ID = id
+	}
+	P.steps = append(P.steps, step)
+	return id
+}
+
+// Returns the placeholders handed out so far, each mapped to itself.
+func (P *Plan) placeholders() map[int]int {
+	P.mutex.Lock()
+	defer P.mutex.Unlock()
+	ids := make(map[int]int)
+	for _, step := range P.steps {
+		if step.ID != 0 {
+			ids[step.ID] = step.ID
+		}
+	}
+	return ids
+}
+
+// Returns a copy of the steps recorded.
+func (P *Plan) Steps() []PlanStep {
+	P.mutex.Lock()
+	defer P.mutex.Unlock()
+	return append([]PlanStep(nil), P.steps...)
+}
+
+// Returns the number of steps recorded.
+func (P *Plan) Len() int {
+	P.mutex.Lock()
+	defer P.mutex.Unlock()
+	return len(P.steps)
+}
+
+// Records req to the plan when in plan mode, returns true if req should not be sent.
+func (s KWSession) planCall(req APIRequest) (bool, error) {
+	if s.plan == nil {
+		return false, nil
+	}
+
+	method := strings.ToUpper(req.Method)
+
+	if method == "GET" || method == "HEAD" {
+		id := placeholderIn(req.Path)
+		if id == 0 {
+			return false, nil
+		}
+		// The entity only exists in the plan, answer as kiteworks would for an empty one.
+		planOutput(req.Output, id)
+		return true, nil
+	}
+
+	step := PlanStep{
+		Username: s.Username,
+		Method:   method,
+		Path:     req.Path,
+		APIVer:   req.APIVer,
+	}
+
+	// Only values matching a placeholder already handed out are replaced on replay.
+	ids := s.plan.placeholders()
+	refs := func(ref string, v interface{}) {
+		r := &replacer{ids: ids}
+		r.value(v)
+		if r.found {
+			step.Refs = append(step.Refs, ref)
+		}
+	}
+	refs("path", req.Path)
+
+	for _, in := range req.Params {
+		if q, ok := in.(*QueryBuilder); ok {
+			in = q.Query()
+		}
+		switch i := in.(type) {
+		case Query:
+			if step.Query == nil {
+				step.Query = make(map[string]string)
+			}
+			for k, v := range i {
+				step.Query[k] = Spanner(v)
+				refs("query."+k, step.Query[k])
+			}
+		case PostForm:
+			step.Form = make(map[string]string)
+			for k, v := range i {
+				step.Form[k] = Spanner(v)
+				refs("form."+k, step.Form[k])
+			}
+		case PostJSON:
+			step.JSON = make(map[string]interface{})
+			for k, v := range i {
+				step.JSON[k] = v
+				refs("json."+k, v)
+			}
+		case nil:
+			continue
+		default:
+			return true, fmt.Errorf("Unknown request exception.")
+		}
+	}
+
+	planOutput(req.Output, s.plan.add(step, req.Output != nil))
+	return true, nil
+}
+
+// Records an upload to the plan, returning the placeholder for the file ID.
+func (s KWSession) planUpload(filename string, upload_id int, source ReadSeekCloser) int {
+	step := PlanStep{
+		Username: s.Username,
+		Method:   "UPLOAD",
+		Filename: filename,
+		UploadID: upload_id,
+		source:   source,
+	}
+	if f, ok := source.(*os.File); ok {
+		step.Source = f.Name()
+	}
+	if _, ok := s.plan.placeholders()[upload_id]; ok {
+		step.Refs = []string{"upload_id"}
+	}
+	return s.plan.add(step, true)
+}
+
+// Fills output with an empty result carrying id.
+func planOutput(output interface{}, id int) {
+	if output == nil {
+		return
+	}
+	json.Unmarshal([]byte(fmt.Sprintf(`{"id":%d,"data":[],"metadata":{"total":0}}`, id)), output)
+}
+
+// Returns the last placeholder ID in path, or 0 if there is none.
+func placeholderIn(path string) (id int) {
+	for _, v := range strings.Split(path, "/") {
+		if n, err := strconv.Atoi(v); err == nil && n < 0 {
+			id = n
+		}
+	}
+	return
+}
+
+// Sends the steps of plan for real, IDs returned by earlier steps replace their placeholders in later steps.
+func (s KWSession) Replay(plan *Plan) error {
+	return s.ReplayContext(context.Background(), plan)
+}
+
+// Replay bound to ctx, replay stops at the first step which fails.
+// Nothing is sent if any step was recorded by a user other than the user of the session.
+func (s KWSession) ReplayContext(ctx context.Context, plan *Plan) error {
+	s.plan = nil
+
+	steps := plan.Steps()
+
+	for i, step := range steps {
+		if step.Username != NONE && step.Username != s.Username {
+			return fmt.Errorf("plan step %d, %s: recorded for %s, not %s.", i+1, step.String(), step.Username, s.Username)
+		}
+	}
+
+	ids := make(map[int]int)
+	for _, step := range steps {
+		if step.ID != 0 {
+			ids[step.ID] = 0
+		}
+	}
+
+	for i, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		id, err := s.replayStep(ctx, step, ids)
+		if err != nil {
+			return fmt.Errorf("plan step %d, %s: %w", i+1, step.String(), err)
+		}
+		if step.ID != 0 {
+			ids[step.ID] = id
+		}
+	}
+
+	return nil
+}
+
+// Sends step, returning the ID of the entity returned.
+func (s KWSession) replayStep(ctx context.Context, step PlanStep, ids map[int]int) (int, error) {
+	r := &replacer{ids: ids}
+
+	refs := make(map[string]bool, len(step.Refs))
+	for _, ref := range step.Refs {
+		refs[ref] = true
+	}
+
+	// Returns v with placeholders replaced if ref holds any, v otherwise.
+	replace := func(ref string, v interface{}) interface{} {
+		if !refs[ref] {
+			return v
+		}
+		return r.value(v)
+	}
+
+	// Plans read from JSON may have been written by hand.
+	step.Method = strings.ToUpper(step.Method)
+
+	if step.Method == "UPLOAD" {
+		upload_id := replace("upload_id", step.UploadID).(int)
+		if r.err != nil {
+			return -1, r.err
+		}
+		var source ReadSeekCloser
+		switch {
+		case step.Source != NONE:
+			f, err := os.Open(step.Source)
+			if err != nil {
+				return -1, err
+			}
+			defer f.Close()
+			source = f
+		case step.source != nil:
+			if _, err := step.source.Seek(0, 0); err != nil {
+				return -1, err
+			}
+			source = step.source
+		default:
+			return -1, fmt.Errorf("Source of %s is not available for upload.", step.Filename)
+		}
+		return s.UploadContext(ctx, step.Filename, upload_id, source)
+	}
+
+	var params []interface{}
+	if step.Query != nil {
+		q := make(Query, len(step.Query))
+		for k, v := range step.Query {
+			q[k] = replace("query."+k, v)
+		}
+		params = append(params, q)
+	}
+	if step.Form != nil {
+		f := make(PostForm, len(step.Form))
+		for k, v := range step.Form {
+			f[k] = replace("form."+k, v)
+		}
+		params = append(params, f)
+	}
+	if step.JSON != nil {
+		j := make(PostJSON, len(step.JSON))
+		for k, v := range step.JSON {
+			j[k] = replace("json."+k, v)
+		}
+		params = append(params, j)
+	}
+
+	path := replace("path", step.Path).(string)
+	if r.err != nil {
+		return -1, r.err
+	}
+
+	var output json.RawMessage
+
+	if err := s.CallContext(ctx, APIRequest{
+		APIVer: step.APIVer,
+		Method: step.Method,
+		Path:   path,
+		Params: params,
+		Output: &output,
+	}); err != nil {
+		return -1, err
+	}
+
+	var entity struct {
+		ID int `json:"id"`
+	}
+	if len(output) > 0 {
+		json.Unmarshal(output, &entity)
+	}
+	return entity.ID, nil
+}
+
+// Swaps placeholders for the IDs returned by earlier steps.
+type replacer struct {
+	ids   map[int]int
+	found bool // A placeholder was seen.
+	err   error
+}
+
+// Returns the ID standing in for placeholder n, or n if it is not a placeholder.
+func (r *replacer) int(n int) int {
+	id, ok := r.ids[n]
+	if !ok {
+		return n
+	}
+	r.found = true
+	if id == 0 && r.err == nil {
+		r.err = fmt.Errorf("depends on plan step %d, which returned no ID.", -n)
+	}
+	return id
+}
+
+// Replaces placeholders within the path or comma separated list v.
+func (r *replacer) string(v string) string {
+	for _, sep := range []string{"/", ","} {
+		if strings.Contains(v, sep) {
+			elements := strings.Split(v, sep)
+			for i, e := range elements {
+				elements[i] = r.string(e)
+			}
+			return strings.Join(elements, sep)
+		}
+	}
+	if n, err := strconv.Atoi(v); err == nil && n < 0 {
+		return strconv.Itoa(r.int(n))
+	}
+	return v
+}
+
+// Replaces placeholders within a JSON value.
+func (r *replacer) value(v interface{}) interface{} {
+	switch i := v.(type) {
+	case string:
+		return r.string(i)
+	case int:
+		return r.int(i)
+	case int64:
+		return int64(r.int(int(i)))
+	case float64:
+		if i == float64(int(i)) {
+			return r.int(int(i))
+		}
+	case json.Number:
+		if n, err := i.Int64(); err == nil {
+			return r.int(int(n))
+		}
+	case []int:
+		output := make([]int, len(i))
+		for k, n := range i {
+			output[k] = r.int(n)
+		}
+		return output
+	case []interface{}:
+		output := make([]interface{}, len(i))
+		for k, e := range i {
+			output[k] = r.value(e)
+		}
+		return output
+	case map[string]interface{}:
+		output := make(map[string]interface{}, len(i))
+		for k, e := range i {
+			output[k] = r.value(e)
+		}
+		return output
+	}
+	return v
+}
+
+// Writes the plan as a table, one step per line.
+func (P *Plan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tUSER\tMETHOD\tPATH\tPARAMS\tID")
+	for i, step := range P.Steps() {
+		path := step.Path
+		var params []string
+		if step.Method == "UPLOAD" {
+			path = step.Filename
+			params = append(params, fmt.Sprintf("upload_id=%d", step.UploadID))
+			if step.Source != NONE {
+				params = append(params, "source="+step.Source)
+			}
+		}
+		for _, m := range []map[string]string{step.Query, step.Form} {
+			for k, v := range m {
+				params = append(params, k+"="+v)
+			}
+		}
+		for k, v := range step.JSON {
+			b, _ := json.Marshal(v)
+			params = append(params, k+"="+string(b))
+		}
+		sort.Strings(params)
+		id := NONE
+		if step.ID != 0 {
+			id = strconv.Itoa(step.ID)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", i+1, step.Username, step.Method, path, strings.Join(params, " "), id)
+	}
+	return tw.Flush()
+}
+
+// Writes the plan as JSON, which ReadPlan loads for replay.
+func (P *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Steps []PlanStep `json:"steps"`
+	}{P.Steps()})
+}
+
+// Loads a plan written by WriteJSON.
+func ReadPlan(r io.Reader) (*Plan, error) {
+	var input struct {
+		Steps []PlanStep `json:"steps"`
+	}
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&input); err != nil {
+		return nil, err
+	}
+	return &Plan{steps: input.Steps}, nil
+}
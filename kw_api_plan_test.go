@@ -0,0 +1,167 @@
+package kwlib_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cmcoffee/go-kwlib"
+)
+
+func TestPlan(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	// Records the mark query of each call.
+	var (
+		lock  sync.Mutex
+		marks []string
+	)
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mark := r.URL.Query().Get("mark"); mark != "" {
+			lock.Lock()
+			marks = append(marks, mark)
+			lock.Unlock()
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer plain.Close()
+	s.BaseURL = plain.URL
+
+	var plan kwlib.Plan
+	p := s.PlanMode(&plan)
+
+	reports, err := p.Folders().Create(user.BaseDirID, "Reports")
+	if err != nil || reports.ID >= 0 {
+		t.Fatalf("planned folder %+v, %v", reports, err)
+	}
+
+	// -3 is the placeholder of a later step, so is sent as is.
+	var archive kwlib.KWFolder
+	err = p.Call(kwlib.APIRequest{
+		Method: "POST",
+		Path:   kwlib.SetPath("/rest/folders/%d/folders", reports.ID),
+		Params: kwlib.SetParams(kwlib.PostJSON{"name": "Archive"}, kwlib.Query{"returnEntity": true, "mark": -3}),
+		Output: &archive,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("quarterly figures")
+	upload_id, err := p.NewUpload(archive.ID, "q1.txt", int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(tempFile(t, "q1.txt", content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := p.Upload("q1.txt", upload_id, f); err != nil {
+		t.Fatal(err)
+	}
+
+	if folders, files, err := s.Folders().List(user.BaseDirID); err != nil || len(folders)+len(files) != 0 {
+		t.Fatalf("plan mode reached the server: %+v %+v, %v", folders, files, err)
+	}
+
+	var table bytes.Buffer
+	if err := plan.WriteTable(&table); err != nil {
+		t.Fatal(err)
+	}
+	if plan.Len() != 4 || !strings.Contains(table.String(), "UPLOAD") {
+		t.Fatalf("unexpected plan:\n%s", table.String())
+	}
+
+	var output bytes.Buffer
+	if err := plan.WriteJSON(&output); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := kwlib.ReadPlan(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Plans are only replayed by the user who recorded them.
+	other_user := srv.AddUser("other@example.com", "password")
+	other, err := srv.KWAPI().Authenticate(other_user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Replay(loaded); err == nil {
+		t.Fatal("plan replayed by another user")
+	}
+	if folders, _, err := s.Folders().List(user.BaseDirID); err != nil || len(folders) != 0 {
+		t.Fatalf("refused plan reached the server: %+v, %v", folders, err)
+	}
+
+	if err := s.Replay(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(marks) != 1 || marks[0] != "-3" {
+		t.Fatalf("sent mark %v, expected -3", marks)
+	}
+
+	folders, _, err := s.Folders().List(user.BaseDirID)
+	if err != nil || len(folders) != 1 || folders[0].Name != "Reports" {
+		t.Fatalf("replayed folders %+v, %v", folders, err)
+	}
+	folders, _, err = s.Folders().List(folders[0].ID)
+	if err != nil || len(folders) != 1 || folders[0].Name != "Archive" {
+		t.Fatalf("replayed folders %+v, %v", folders, err)
+	}
+	_, files, err := s.Folders().List(folders[0].ID)
+	if err != nil || len(files) != 1 || files[0].Name != "q1.txt" {
+		t.Fatalf("replayed files %+v, %v", files, err)
+	}
+	checkContent(t, srv, files[0].ID, content)
+
+	// Replaying again fails on the folder which now exists.
+	if err := s.Replay(loaded); !kwlib.KWAPIError(err, kwlib.ERR_ENTITY_EXISTS) {
+		t.Fatalf("expected ERR_ENTITY_EXISTS, got %v", err)
+	}
+}
+
+func TestPlanMethodCase(t *testing.T) {
+	_, s, user := testSession(t)
+
+	var plan kwlib.Plan
+	p := s.PlanMode(&plan)
+
+	var folder kwlib.KWFolder
+	err := p.Call(kwlib.APIRequest{
+		Method: "post",
+		Path:   kwlib.SetPath("/rest/folders/%d/folders", user.BaseDirID),
+		Params: kwlib.SetParams(kwlib.PostJSON{"name": "Reports"}, kwlib.Query{"returnEntity": true}),
+		Output: &folder,
+	})
+	if err != nil || folder.ID >= 0 {
+		t.Fatalf("planned folder %+v, %v", folder, err)
+	}
+
+	// Reads of planned entities are answered by the plan, whatever the case of the method.
+	var planned kwlib.KWFolder
+	err = p.Call(kwlib.APIRequest{
+		Method: "get",
+		Path:   kwlib.SetPath("/rest/folders/%d", folder.ID),
+		Output: &planned,
+	})
+	if err != nil || planned.ID != folder.ID {
+		t.Fatalf("planned read %+v, %v", planned, err)
+	}
+
+	if steps := plan.Steps(); len(steps) != 1 || steps[0].Method != "POST" {
+		t.Fatalf("unexpected plan steps %+v", steps)
+	}
+
+	if err := s.Replay(&plan); err != nil {
+		t.Fatal(err)
+	}
+	if folders, _, err := s.Folders().List(user.BaseDirID); err != nil || len(folders) != 1 || folders[0].Name != "Reports" {
+		t.Fatalf("replayed folders %+v, %v", folders, err)
+	}
+}
@@ -0,0 +1,118 @@
+package kwlib
+
+import (
+	"strings"
+	"time"
+)
+
+// Builds kiteworks filter, sort and field selection parameters, pass to SetParams like a Query.
+type QueryBuilder struct {
+	query Query
+	order []string
+}
+
+// Starts a new QueryBuilder.
+func NewQuery() *QueryBuilder {
+	return &QueryBuilder{query: make(Query)}
+}
+
+// Sets key to value, replacing any existing value.
+func (Q *QueryBuilder) Set(key string, value interface{}) *QueryBuilder {
+	Q.query[key] = value
+	return Q
+}
+
+// Adds fields to the with projection, ie.. with=(id,name).
+func (Q *QueryBuilder) With(fields ...string) *QueryBuilder {
+	return Q.projection("with", fields)
+}
+
+// Adds fields to the without projection, ie.. without=(permissions).
+func (Q *QueryBuilder) Without(fields ...string) *QueryBuilder {
+	return Q.projection("without", fields)
+}
+
+// Merges fields into a parenthesized field list.
+func (Q *QueryBuilder) projection(key string, fields []string) *QueryBuilder {
+	var existing []string
+	if v, ok := Q.query[key].(string); ok {
+		v = strings.TrimSuffix(strings.TrimPrefix(v, "("), ")")
+		if v != NONE {
+			existing = strings.Split(v, ",")
+		}
+	}
+	for _, f := range fields {
+		found := false
+		for _, e := range existing {
+			if e == f {
+				found = true
+				break
+			}
+		}
+		if !found {
+			existing = append(existing, f)
+		}
+	}
+	Q.query[key] = "(" + strings.Join(existing, ",") + ")"
+	return Q
+}
+
+// Sorts results by field, further calls add secondary sort fields.
+func (Q *QueryBuilder) OrderBy(field string, descending bool) *QueryBuilder {
+	if descending {
+		Q.order = append(Q.order, field+":desc")
+	} else {
+		Q.order = append(Q.order, field+":asc")
+	}
+	Q.query["orderBy"] = strings.Join(Q.order, ",")
+	return Q
+}
+
+// Matches entities where field equals value.
+func (Q *QueryBuilder) Equals(field string, value interface{}) *QueryBuilder {
+	return Q.Set(field, value)
+}
+
+// Matches entities where field contains value, ie.. name:contains.
+func (Q *QueryBuilder) Contains(field, value string) *QueryBuilder {
+	return Q.Set(field+":contains", value)
+}
+
+// Matches entities where field is one of values, values may be a []string or []int.
+func (Q *QueryBuilder) In(field string, values interface{}) *QueryBuilder {
+	return Q.Set(field+":in", values)
+}
+
+// Matches entities with the IDs provided.
+func (Q *QueryBuilder) IDs(ids ...int) *QueryBuilder {
+	return Q.In("id", ids)
+}
+
+// Matches entities where the date field is at or after t.
+func (Q *QueryBuilder) Since(field string, t time.Time) *QueryBuilder {
+	return Q.Set(field+":gte", WriteKWTime(t))
+}
+
+// Matches entities where the date field is before t.
+func (Q *QueryBuilder) Before(field string, t time.Time) *QueryBuilder {
+	return Q.Set(field+":lt", WriteKWTime(t))
+}
+
+// Matches entities where the date field is at or after from and before to.
+func (Q *QueryBuilder) Between(field string, from, to time.Time) *QueryBuilder {
+	return Q.Since(field, from).Before(field, to)
+}
+
+// Includes or excludes deleted entities.
+func (Q *QueryBuilder) Deleted(deleted bool) *QueryBuilder {
+	return Q.Set("deleted", deleted)
+}
+
+// Returns a copy of the parameters built.
+func (Q *QueryBuilder) Query() Query {
+	output := make(Query, len(Q.query))
+	for k, v := range Q.query {
+		output[k] = v
+	}
+	return output
+}
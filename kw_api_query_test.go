@@ -0,0 +1,119 @@
+package kwlib_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmcoffee/go-kwlib"
+)
+
+func TestQueryBuilder(t *testing.T) {
+	q := kwlib.NewQuery().With("id", "name").With("name", "size").Without("permissions").
+		OrderBy("name", false).OrderBy("modified", true).Contains("name", "rep").IDs(1, 2, 3).
+		Between("modified", time.Unix(0, 0), time.Unix(60, 0)).Deleted(false)
+
+	var got kwlib.Query
+	for _, p := range kwlib.SetParams(kwlib.PostJSON{"a": 1}, q, kwlib.Query{"limit": 5}) {
+		if x, ok := p.(kwlib.Query); ok {
+			got = x
+		}
+	}
+
+	expected := map[string]string{
+		"with":          "(id,name,size)",
+		"without":       "(permissions)",
+		"orderBy":       "name:asc,modified:desc",
+		"name:contains": "rep",
+		"id:in":         "1,2,3",
+		"modified:gte":  "1970-01-01T00:00:00+0000",
+		"modified:lt":   "1970-01-01T00:01:00+0000",
+		"deleted":       "false",
+		"limit":         "5",
+	}
+	for k, v := range expected {
+		if kwlib.Spanner(got[k]) != v {
+			t.Errorf("%s = %v, expected %s", k, got[k], v)
+		}
+	}
+	if len(q.Query()) != 8 {
+		t.Fatalf("builder modified by SetParams: %v", q.Query())
+	}
+}
+
+func TestQueryBuilderCall(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	// Records the query of each folder listing.
+	var (
+		lock    sync.Mutex
+		queries []url.Values
+	)
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/folders") {
+			lock.Lock()
+			queries = append(queries, r.URL.Query())
+			lock.Unlock()
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer plain.Close()
+	s.BaseURL = plain.URL
+
+	srv.AddFolder(user.BaseDirID, "Reports")
+
+	var folders []kwlib.KWFolder
+	err := s.DataCall(kwlib.APIRequest{
+		Method: "GET",
+		Path:   kwlib.SetPath("/rest/folders/%d/folders", user.BaseDirID),
+		Params: kwlib.SetParams(kwlib.NewQuery().With("id", "name").OrderBy("name", true).Deleted(false)),
+		Output: &folders,
+	}, -1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != 1 || folders[0].Name != "Reports" {
+		t.Fatalf("unexpected folders %+v", folders)
+	}
+
+	if len(queries) != 1 {
+		t.Fatalf("recorded %d listings, expected 1", len(queries))
+	}
+	for k, v := range map[string]string{"with": "(id,name)", "orderBy": "name:desc", "deleted": "false", "limit": "10", "offset": "0"} {
+		if queries[0].Get(k) != v {
+			t.Errorf("sent %s=%q, expected %q", k, queries[0].Get(k), v)
+		}
+	}
+
+	// A QueryBuilder placed in Params without SetParams is sent as well.
+	err = s.Call(kwlib.APIRequest{
+		Method: "GET",
+		Path:   kwlib.SetPath("/rest/folders/%d/folders", user.BaseDirID),
+		Params: []interface{}{kwlib.NewQuery().With("id").Deleted(true)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 || queries[1].Get("with") != "(id)" || queries[1].Get("deleted") != "true" {
+		t.Fatalf("sent %v", queries[len(queries)-1])
+	}
+
+	// And recorded in plan mode.
+	var plan kwlib.Plan
+	p := s.PlanMode(&plan)
+	err = p.Call(kwlib.APIRequest{
+		Method: "POST",
+		Path:   kwlib.SetPath("/rest/folders/%d/folders", user.BaseDirID),
+		Params: []interface{}{kwlib.PostJSON{"name": "Planned"}, kwlib.NewQuery().Set("returnEntity", true)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps := plan.Steps(); len(steps) != 1 || steps[0].Query["returnEntity"] != "true" {
+		t.Fatalf("planned %+v", steps)
+	}
+}
@@ -0,0 +1,313 @@
+package kwlib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Uploads kept in a Database table, so uploads interrupted by a crash resume where they stopped on restart.
+type UploadQueue struct {
+	session KWSession
+	db      *Database
+	table   string
+	mutex   sync.Mutex
+}
+
+// Pending upload in an UploadQueue.
+type UploadJob struct {
+	Key       string  // Identifies the job within the queue.
+	Path      string  // Local file to upload.
+	FolderID  int     // Folder receiving a new file.
+	FileID    int     // File receiving a new version, 0 for a new file until Finished.
+	UploadID  int     // kiteworks upload, 0 until the upload is initiated.
+	Pending   []int64 // Chunks kiteworks has yet to accept, when a concurrent upload failed.
+	Size      int64   // Size of the local file when queued.
+	MD5       string  // MD5 of the local file when queued.
+	Finished  bool    // Upload complete, FileID holds the file uploaded.
+	Attempts  int     // Runs which have attempted the job.
+	LastError string  // Error from the last attempt.
+}
+
+// Returns an UploadQueue kept in table of db.
+func (s KWSession) NewUploadQueue(db *Database, table string) *UploadQueue {
+	if table == NONE {
+		table = "kw_upload_queue"
+	}
+	return &UploadQueue{session: s, db: db, table: table}
+}
+
+// Queues local file path for upload to folder_id, returns the key of the job.
+func (Q *UploadQueue) Add(path string, folder_id int) (string, error) {
+	return Q.add(UploadJob{Path: path, FolderID: folder_id})
+}
+
+// Queues local file path for upload as a new version of file_id, returns the key of the job.
+func (Q *UploadQueue) AddVersion(path string, file_id int) (string, error) {
+	return Q.add(UploadJob{Path: path, FileID: file_id})
+}
+
+// Records job, replacing any job for the same file and destination.
+func (Q *UploadQueue) add(job UploadJob) (string, error) {
+	path, err := filepath.Abs(job.Path)
+	if err != nil {
+		return NONE, err
+	}
+	job.Path = path
+
+	finfo, err := os.Stat(job.Path)
+	if err != nil {
+		return NONE, err
+	}
+	if !finfo.Mode().IsRegular() {
+		return NONE, fmt.Errorf("%s: not a regular file.", job.Path)
+	}
+	job.Size = finfo.Size()
+
+	if job.MD5, err = MD5Sum(job.Path); err != nil {
+		return NONE, err
+	}
+
+	if job.FileID > 0 {
+		job.Key = fmt.Sprintf("file:%d:%s", job.FileID, job.Path)
+	} else {
+		job.Key = fmt.Sprintf("folder:%d:%s", job.FolderID, job.Path)
+	}
+
+	Q.mutex.Lock()
+	defer Q.mutex.Unlock()
+
+	var existing UploadJob
+	if found, err := Q.db.TryGet(Q.table, job.Key, &existing); err != nil {
+		return NONE, err
+	} else if found && existing.UploadID > 0 && !existing.Finished {
+		Q.session.cancelUpload(existing.UploadID)
+	}
+
+	return job.Key, Q.db.TrySet(Q.table, job.Key, job)
+}
+
+// Returns the jobs pending, ordered by key.
+func (Q *UploadQueue) Jobs() ([]UploadJob, error) {
+	Q.mutex.Lock()
+	defer Q.mutex.Unlock()
+
+	keys, err := Q.db.TryKeys(Q.table)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	jobs := make([]UploadJob, 0, len(keys))
+	for _, k := range keys {
+		var job UploadJob
+		found, err := Q.db.TryGet(Q.table, k, &job)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// Returns the number of jobs pending.
+func (Q *UploadQueue) Len() (int, error) {
+	return Q.db.TryCountKeys(Q.table)
+}
+
+// Drops the job key, cancelling its upload on kiteworks.
+func (Q *UploadQueue) Remove(key string) error {
+	Q.mutex.Lock()
+	defer Q.mutex.Unlock()
+
+	var job UploadJob
+	found, err := Q.db.TryGet(Q.table, key, &job)
+	if err != nil || !found {
+		return err
+	}
+	if job.UploadID > 0 && !job.Finished {
+		Q.session.cancelUpload(job.UploadID)
+	}
+	return Q.db.TryUnset(Q.table, key)
+}
+
+// Saves job.
+func (Q *UploadQueue) save(job UploadJob) error {
+	Q.mutex.Lock()
+	defer Q.mutex.Unlock()
+	if err := Q.db.TrySet(Q.table, job.Key, job); err != nil {
+		return fmt.Errorf("%s: %s", job.Key, err.Error())
+	}
+	return nil
+}
+
+// Drops job once complete.
+func (Q *UploadQueue) done(job UploadJob) error {
+	Q.mutex.Lock()
+	defer Q.mutex.Unlock()
+	if err := Q.db.TryUnset(Q.table, job.Key); err != nil {
+		return fmt.Errorf("%s: %s", job.Key, err.Error())
+	}
+	return nil
+}
+
+// Uploads each pending job, returns file IDs of the completed jobs by key.
+func (Q *UploadQueue) Run() (map[string]int, error) {
+	return Q.RunContext(context.Background())
+}
+
+// Run bound to ctx, jobs not completed remain queued for the next run.
+// Returns an error summarizing the jobs which failed.
+func (Q *UploadQueue) RunContext(ctx context.Context) (map[string]int, error) {
+	jobs, err := Q.Jobs()
+	if err != nil {
+		return nil, err
+	}
+
+	completed := make(map[string]int)
+
+	var failed []string
+
+	for _, job := range jobs {
+		if err := ctx.Err(); err != nil {
+			return completed, err
+		}
+		file_id, err := Q.runJob(ctx, job)
+		if err != nil {
+			if ctx.Err() != nil {
+				return completed, ctx.Err()
+			}
+			failed = append(failed, fmt.Sprintf("%s: %s", job.Path, err.Error()))
+			continue
+		}
+		completed[job.Key] = file_id
+	}
+
+	if len(failed) > 0 {
+		return completed, fmt.Errorf("%d of %d failed: %s", len(failed), len(jobs), strings.Join(failed, "; "))
+	}
+	return completed, nil
+}
+
+// Uploads job, resuming its upload when kiteworks still holds it and the local file is unchanged.
+func (Q *UploadQueue) runJob(ctx context.Context, job UploadJob) (file_id int, err error) {
+	job.Attempts++
+
+	var dropped bool
+
+	defer func() {
+		if err != nil && ctx.Err() == nil && !dropped {
+			job.LastError = err.Error()
+			if save_err := Q.save(job); save_err != nil {
+				Err(save_err)
+			}
+		}
+	}()
+
+	// Uploaded by an earlier run, which could not drop the job.
+	if job.Finished {
+		if err = Q.done(job); err != nil {
+			return -1, err
+		}
+		return job.FileID, nil
+	}
+
+	finfo, err := os.Stat(job.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Nothing left to upload.
+			if job.UploadID > 0 {
+				Q.session.cancelUpload(job.UploadID)
+			}
+			if done_err := Q.done(job); done_err != nil {
+				return -1, done_err
+			}
+			dropped = true
+		}
+		return -1, err
+	}
+
+	if job.UploadID > 0 {
+		resume := finfo.Size() == job.Size
+		if resume {
+			sum, err := MD5Sum(job.Path)
+			if err != nil {
+				return -1, err
+			}
+			resume = sum == job.MD5
+		}
+		if resume {
+			record, err := Q.session.uploadRecord(ctx, job.UploadID)
+			if err != nil && err != ErrNoUploadID {
+				return -1, err
+			}
+			resume = err == nil && !record.Finished && record.TotalSize == job.Size
+		}
+		if !resume {
+			Debug("Upload %d of %s can not be resumed, starting over.", job.UploadID, job.Path)
+			Q.session.cancelUpload(job.UploadID)
+			job.UploadID = 0
+			job.Pending = nil
+		}
+	}
+
+	name := filepath.Base(job.Path)
+
+	if job.UploadID == 0 {
+		job.Size = finfo.Size()
+		if job.MD5, err = MD5Sum(job.Path); err != nil {
+			return -1, err
+		}
+		if job.FileID > 0 {
+			job.UploadID, err = Q.session.NewVersion(job.FileID, name, job.Size)
+		} else {
+			job.UploadID, err = Q.session.NewUpload(job.FolderID, name, job.Size)
+		}
+		if err != nil {
+			job.UploadID = 0
+			return -1, err
+		}
+		job.Pending = nil
+		// Record the upload before sending anything, so a crash from here on can resume it.
+		// An upload which could not be recorded would be lost to the queue, so is not sent.
+		if err = Q.save(job); err != nil {
+			Q.session.cancelUpload(job.UploadID)
+			job.UploadID = 0
+			return -1, err
+		}
+	}
+
+	f, err := os.Open(job.Path)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	// Chunks a concurrent upload left behind are only known to the process which sent them.
+	if len(job.Pending) > 0 {
+		if _, ok := Q.session.chunkGaps(job.UploadID); !ok {
+			Q.session.setChunkGaps(job.UploadID, job.Pending)
+		}
+	}
+
+	if file_id, err = Q.session.UploadContext(ctx, name, job.UploadID, f); err != nil {
+		job.Pending, _ = Q.session.chunkGaps(job.UploadID)
+		return -1, err
+	}
+
+	// Marked finished before the job is dropped, so a job which can not be dropped is not uploaded again.
+	job.Finished, job.FileID, job.Pending = true, file_id, nil
+	if err = Q.save(job); err != nil {
+		Err(err)
+	}
+	if err = Q.done(job); err != nil {
+		return file_id, err
+	}
+	return file_id, nil
+}
@@ -0,0 +1,206 @@
+package kwlib_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cmcoffee/go-kwlib"
+)
+
+// Returns the number of uploads kiteworks holds open.
+func pendingUploads(t *testing.T, s *kwlib.KWSession) int {
+	var output struct {
+		Data []kwlib.KWUpload `json:"data"`
+	}
+	if err := s.Call(kwlib.APIRequest{Method: "GET", Path: "/rest/uploads", Output: &output}); err != nil {
+		t.Fatal(err)
+	}
+	return len(output.Data)
+}
+
+// Returns the number of jobs in q.
+func queued(t *testing.T, q *kwlib.UploadQueue) int {
+	n, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestUploadQueue(t *testing.T) {
+	srv, s, user := testSession(t)
+	s.Retries = 0
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.bin")
+	b := filepath.Join(dir, "b.bin")
+	a_content := bytes.Repeat([]byte("a"), 3<<20+5)
+	if err := ioutil.WriteFile(a, a_content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(b, []byte("bbb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := kwlib.OpenCache()
+	q := s.NewUploadQueue(db, "")
+	for _, path := range []string{a, b} {
+		if _, err := q.Add(path, user.BaseDirID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Failed jobs stay queued, keeping their upload.
+	srv.Fail("POST", "/rest/uploads/", 422, "ERR_INPUT_INVALID", 2)
+	completed, err := q.Run()
+	if err == nil || len(completed) != 0 || queued(t, q) != 2 {
+		t.Fatalf("completed %v, %v", completed, err)
+	}
+	jobs, err := q.Jobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].UploadID == 0 || jobs[0].LastError == "" || jobs[0].Attempts != 1 {
+		t.Fatalf("unexpected job %+v", jobs[0])
+	}
+
+	// A new queue over the same database resumes, starting over for the file which changed meanwhile.
+	if err := ioutil.WriteFile(b, []byte("bbbb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q = s.NewUploadQueue(db, "")
+	completed, err = q.Run()
+	if err != nil || len(completed) != 2 || queued(t, q) != 0 {
+		t.Fatalf("completed %v, %v", completed, err)
+	}
+	if n := pendingUploads(t, s); n != 0 {
+		t.Fatalf("%d uploads left open", n)
+	}
+
+	_, files, err := s.Folders().List(user.BaseDirID)
+	if err != nil || len(files) != 2 {
+		t.Fatalf("uploaded %+v, %v", files, err)
+	}
+	for _, f := range files {
+		switch f.Name {
+		case "a.bin":
+			checkContent(t, srv, f.ID, a_content)
+		case "b.bin":
+			checkContent(t, srv, f.ID, []byte("bbbb"))
+			if err := ioutil.WriteFile(b, []byte("v2"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := q.AddVersion(b, f.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Jobs for files which no longer exist are dropped.
+	gone := filepath.Join(dir, "gone.bin")
+	if err := ioutil.WriteFile(gone, []byte("gone"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Add(gone, user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	completed, err = q.Run()
+	if err == nil || len(completed) != 1 || queued(t, q) != 0 {
+		t.Fatalf("completed %v, %v", completed, err)
+	}
+}
+
+func TestUploadQueueFinished(t *testing.T) {
+	_, s, user := testSession(t)
+
+	path := tempFile(t, "done.bin", []byte("done"))
+
+	db := kwlib.OpenCache()
+	q := s.NewUploadQueue(db, "")
+	key, err := q.Add(path, user.BaseDirID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As left by a run which uploaded the file, but could not drop the job.
+	jobs, err := q.Jobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := jobs[0]
+	job.Finished, job.FileID = true, 1234
+	db.Set("kw_upload_queue", key, job)
+
+	completed, err := q.Run()
+	if err != nil || completed[key] != 1234 || queued(t, q) != 0 {
+		t.Fatalf("completed %v, %v", completed, err)
+	}
+	if _, files, err := s.Folders().List(user.BaseDirID); err != nil || len(files) != 0 {
+		t.Fatalf("finished job uploaded again: %+v, %v", files, err)
+	}
+}
+
+func TestUploadQueueParallel(t *testing.T) {
+	srv, s, user := testSession(t)
+	s.UploadThreads = 3
+	s.Retries = 0
+
+	// Fails the third chunk once, after the chunks following it have been received.
+	var failed int32
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/rest/uploads/") && r.Method == "POST" {
+			body, _ := ioutil.ReadAll(r.Body)
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if bytes.Contains(body, []byte("name=\"index\"\r\n\r\n3\r\n")) && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+				time.Sleep(250 * time.Millisecond)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				w.Write([]byte(`{"errors":[{"code":"ERR_INPUT_INVALID","message":"Bad chunk"}]}`))
+				return
+			}
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer plain.Close()
+	s.BaseURL = plain.URL
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 8<<16)
+	path := tempFile(t, "queued.bin", data)
+
+	db := kwlib.OpenCache()
+	q := s.NewUploadQueue(db, "")
+	if _, err := q.Add(path, user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+	if completed, err := q.Run(); err == nil || len(completed) != 0 {
+		t.Fatalf("completed %v, %v", completed, err)
+	}
+
+	// A new process only knows of the chunks which failed from the queue.
+	K := srv.KWAPI()
+	K.MaxChunkSize = 1 << 20
+	K.BaseURL = plain.URL
+	restarted, err := K.Authenticate(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q = restarted.NewUploadQueue(db, "")
+	completed, err := q.Run()
+	if err != nil || len(completed) != 1 {
+		t.Fatalf("completed %v, %v", completed, err)
+	}
+	for _, file_id := range completed {
+		checkContent(t, srv, file_id, data)
+	}
+}
@@ -0,0 +1,66 @@
+package kwlib
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Token bucket limiting the rate of requests to kiteworks.
+type rate_limiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Configures maximum number of api requests per second, allowing bursts of up to burst requests.
+// A rate of 0 or less removes the limit.
+func (K *KWAPI) SetRateLimit(requests_per_second float64, burst int) {
+	if requests_per_second <= 0 {
+		K.rate_limiter = nil
+		return
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	K.rate_limiter = &rate_limiter{
+		rate:   requests_per_second,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Waits for a token to become available, returns early if ctx is cancelled.
+func (r *rate_limiter) wait(ctx context.Context) error {
+	for {
+		r.mutex.Lock()
+		now := time.Now()
+		r.tokens = r.tokens + now.Sub(r.last).Seconds()*r.rate
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+		r.last = now
+		if r.tokens >= 1 {
+			r.tokens--
+			r.mutex.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+		r.mutex.Unlock()
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Waits for the rate limiter, if one is configured.
+func (K *KWAPI) rateWait(ctx context.Context) error {
+	if K.rate_limiter == nil {
+		return nil
+	}
+	return K.rate_limiter.wait(ctx)
+}
@@ -0,0 +1,70 @@
+package kwlib_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cmcoffee/go-kwlib"
+)
+
+func TestRateLimit(t *testing.T) {
+	_, s, user := testSession(t)
+
+	// 10 calls at 50 per second, after a burst of 1, take at least 9/50ths of a second.
+	s.SetRateLimit(50, 1)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Fatalf("10 calls took %s with a limit of 50 per second", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	srv, s, user := testSession(t)
+	s.Retries = 1
+	s.RetryPolicy = kwlib.ConstantRetry(10 * time.Millisecond)
+
+	// Throttles the first folder request, asking the client to wait a second.
+	var throttled int32
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/rest/folders/") && atomic.CompareAndSwapInt32(&throttled, 0, 1) {
+			w.Header().Set("Retry-After", "1")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"errors":[{"code":"ERR_TOO_MANY_REQUESTS","message":"Slow down"}]}`))
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer plain.Close()
+	s.BaseURL = plain.URL
+
+	start := time.Now()
+	if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retried after %s, Retry-After asked for 1s", elapsed)
+	}
+
+	// Without retries, the delay requested is available from the error.
+	s.Retries = 0
+	atomic.StoreInt32(&throttled, 0)
+	_, err := s.Folders().Get(user.BaseDirID)
+	var kw_err *kwlib.KWError
+	if !errors.As(err, &kw_err) || !errors.Is(err, kwlib.ErrTooManyRequests) {
+		t.Fatalf("expected ERR_TOO_MANY_REQUESTS, got %v", err)
+	}
+	if kw_err.StatusCode() != http.StatusTooManyRequests || kw_err.RetryAfter() != time.Second {
+		t.Fatalf("error reports status %d, retry after %s", kw_err.StatusCode(), kw_err.RetryAfter())
+	}
+}
@@ -0,0 +1,188 @@
+package kwlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rules for hiding sensitive values in trace and snoop output.
+type Redactor struct {
+	Mask     string           // Replacement for hidden values, defaults to [HIDDEN].
+	Headers  []string         // HTTP headers to hide, ie.. Authorization.
+	Keys     []string         // Form, query and JSON keys to hide at any depth, case insensitive.
+	Paths    []string         // Dotted JSON paths to hide, * matches any key or array index, ie.. data.*.email.
+	Patterns []*regexp.Regexp // Matches within any remaining values are masked.
+}
+
+// Matches email addresses.
+var email_pattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// Matches signature based authorization codes.
+var auth_code_pattern = regexp.MustCompile(`\S*\|@@\|\S*`)
+
+// Returns a Redactor hiding credentials, signature codes and email addresses, which may be extended.
+func DefaultRedactor() *Redactor {
+	return &Redactor{
+		Mask:    "[HIDDEN]",
+		Headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+		Keys: []string{
+			"access_token",
+			"refresh_token",
+			"client_secret",
+			"password",
+			"signature",
+			"secret",
+		},
+		Patterns: []*regexp.Regexp{auth_code_pattern, email_pattern},
+	}
+}
+
+var default_redactor = DefaultRedactor()
+
+// Returns configured Redactor, or the default.
+func (K *KWAPI) redactor() *Redactor {
+	if K.Redactor != nil {
+		return K.Redactor
+	}
+	return default_redactor
+}
+
+func (R *Redactor) mask() string {
+	if R.Mask == NONE {
+		return "[HIDDEN]"
+	}
+	return R.Mask
+}
+
+// Returns true if key should be hidden.
+func (R *Redactor) hideKey(key string) bool {
+	for _, k := range R.Keys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the JSON path should be hidden.
+func (R *Redactor) hidePath(path []string) bool {
+	for _, p := range R.Paths {
+		segments := strings.Split(p, ".")
+		if len(segments) != len(path) {
+			continue
+		}
+		matched := true
+		for i, v := range segments {
+			if v != "*" && !strings.EqualFold(v, path[i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Masks matches of Patterns within input.
+func (R *Redactor) String(input string) string {
+	for _, p := range R.Patterns {
+		input = p.ReplaceAllString(input, R.mask())
+	}
+	return input
+}
+
+// Returns a copy of header with Headers hidden.
+func (R *Redactor) Header(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+	output := header.Clone()
+	for _, k := range R.Headers {
+		if output.Get(k) != NONE {
+			output.Set(k, R.mask())
+		}
+	}
+	return output
+}
+
+// Returns a copy of form or query values with Keys hidden and Patterns masked.
+func (R *Redactor) Values(values url.Values) url.Values {
+	if values == nil {
+		return nil
+	}
+	output := make(url.Values, len(values))
+	for k, v := range values {
+		for _, value := range v {
+			if R.hideKey(k) {
+				output.Add(k, R.mask())
+			} else {
+				output.Add(k, R.String(value))
+			}
+		}
+	}
+	return output
+}
+
+// Returns body with sensitive values hidden, form and JSON bodies are inspected by key and path.
+func (R *Redactor) Body(content_type string, body []byte) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	switch {
+	case strings.Contains(content_type, "x-www-form-urlencoded"):
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return []byte(R.String(string(body)))
+		}
+		return []byte(R.Values(values).Encode())
+	case strings.Contains(content_type, "json") || json.Valid(body):
+		var generic interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&generic); err != nil {
+			return []byte(R.String(string(body)))
+		}
+		output, err := json.Marshal(R.walk(nil, generic))
+		if err != nil {
+			return []byte(R.String(string(body)))
+		}
+		return output
+	}
+	return []byte(R.String(string(body)))
+}
+
+// Hides values in decoded JSON, path is the location of input.
+func (R *Redactor) walk(path []string, input interface{}) interface{} {
+	switch v := input.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			p := append(path[:len(path):len(path)], k)
+			if R.hideKey(k) || R.hidePath(p) {
+				v[k] = R.mask()
+			} else {
+				v[k] = R.walk(p, value)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			p := append(path[:len(path):len(path)], strconv.Itoa(i))
+			if R.hidePath(p) {
+				v[i] = R.mask()
+			} else {
+				v[i] = R.walk(p, value)
+			}
+		}
+		return v
+	case string:
+		return R.String(v)
+	}
+	return input
+}
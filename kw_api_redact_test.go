@@ -0,0 +1,75 @@
+package kwlib_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cmcoffee/go-kwlib"
+)
+
+func TestRedactedTrace(t *testing.T) {
+	var output bytes.Buffer
+	srv, s, user := tracedSession(t, kwlib.JSONTracer(&output))
+
+	redactor := kwlib.DefaultRedactor()
+	redactor.Paths = append(redactor.Paths, "data.*.name", "data.*.path")
+	s.Redactor = redactor
+
+	srv.AddFolder(user.BaseDirID, "Confidential Reports")
+	srv.Fail("GET", "/rest/folders/", 503, "SERVICE_UNAVAILABLE", 1)
+	s.RetryPolicy = kwlib.ConstantRetry(0)
+	if _, _, err := s.Folders().List(user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+	srv.ExpireTokens()
+	if _, _, err := s.Folders().List(user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+
+	trace := output.String()
+	for _, secret := range []string{user.Email, "Confidential Reports", "kwlibtest_secret", "signature_key", "|@@|", "Bearer "} {
+		if strings.Contains(trace, secret) {
+			t.Fatalf("trace contains %q:\n%s", secret, trace)
+		}
+	}
+	if !strings.Contains(trace, `"username":"[HIDDEN]"`) {
+		t.Fatalf("username not masked:\n%s", trace)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	r := kwlib.DefaultRedactor()
+	r.Mask = "***"
+	r.Paths = []string{"data.*.email"}
+
+	body := r.Body("application/json", []byte(`{"data":[{"email":"a@example.com","id":12345678901234567890}],"nested":{"list":[{"password":"hunter2"}]}}`))
+	for _, hidden := range []string{"a@example.com", "hunter2"} {
+		if bytes.Contains(body, []byte(hidden)) {
+			t.Fatalf("%q not hidden: %s", hidden, body)
+		}
+	}
+	if !bytes.Contains(body, []byte("12345678901234567890")) {
+		t.Fatalf("large number altered: %s", body)
+	}
+
+	form := r.Body("application/x-www-form-urlencoded", []byte("grant_type=password&username=a%40example.com&password=hunter2"))
+	values, err := url.ParseQuery(string(form))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values.Get("grant_type") != "password" || values.Get("username") != "***" || values.Get("password") != "***" {
+		t.Fatalf("unexpected form %s", form)
+	}
+
+	header := r.Header(http.Header{"Authorization": {"Bearer token"}, "Accept": {"application/json"}})
+	if header.Get("Authorization") != "***" || header.Get("Accept") != "application/json" {
+		t.Fatalf("unexpected header %v", header)
+	}
+
+	if got := r.String("/rest/users/a@example.com"); got != "/rest/users/***" {
+		t.Fatalf("String masked %q", got)
+	}
+}
@@ -0,0 +1,171 @@
+package kwlib
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Details of a failed attempt, provided to RetryPolicy.
+type RetryState struct {
+	Attempt    uint          // Attempt which failed, starting at 1.
+	Err        error         // Error returned by the attempt.
+	StatusCode int           // HTTP status code received, 0 if no response was received.
+	RetryAfter time.Duration // Delay requested by the Retry-After header, 0 if not provided.
+}
+
+// RetryPolicy decides if a failed attempt should be retried and how long to wait beforehand.
+// The number of attempts is always capped by KWAPI.Retries.
+type RetryPolicy interface {
+	Retry(state RetryState) (wait time.Duration, retry bool)
+}
+
+// Returns true for failures which are worth another attempt: connection failures,
+// throttling, server side errors, expired tokens and codes registered as CAT_RETRYABLE.
+func Retryable(state RetryState) bool {
+	if IsKWError(state.Err) {
+		return KWAPIError(state.Err, TOKEN_ERR) || errors.Is(state.Err, CAT_RETRYABLE)
+	}
+	switch {
+	case state.StatusCode == 0:
+		return true
+	case state.StatusCode == http.StatusTooManyRequests:
+		return true
+	case state.StatusCode >= 500:
+		return true
+	}
+	return false
+}
+
+// Default policy, waits (attempt^2) seconds between attempts.
+type quadratic_retry struct{}
+
+func (r quadratic_retry) Retry(state RetryState) (time.Duration, bool) {
+	if !Retryable(state) {
+		return 0, false
+	}
+	wait := time.Second * time.Duration(state.Attempt) * time.Duration(state.Attempt)
+	if state.RetryAfter > wait {
+		wait = state.RetryAfter
+	}
+	return wait, true
+}
+
+// Exponential backoff with full jitter.
+type exponential_retry struct {
+	base  time.Duration
+	max   time.Duration
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+// Retries with exponential backoff starting at base, capped at max, with random jitter applied.
+func ExponentialRetry(base, max time.Duration) RetryPolicy {
+	if base <= 0 {
+		base = time.Second
+	}
+	if max < base {
+		max = base
+	}
+	return &exponential_retry{
+		base: base,
+		max:  max,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (r *exponential_retry) Retry(state RetryState) (time.Duration, bool) {
+	if !Retryable(state) {
+		return 0, false
+	}
+
+	ceiling := r.max
+	if state.Attempt < 32 {
+		if d := r.base << (state.Attempt - 1); d > 0 && d < r.max {
+			ceiling = d
+		}
+	}
+
+	r.mutex.Lock()
+	wait := time.Duration(r.rand.Int63n(int64(ceiling) + 1))
+	r.mutex.Unlock()
+
+	if state.RetryAfter > wait {
+		wait = state.RetryAfter
+	}
+	return wait, true
+}
+
+// Constant delay between attempts.
+type constant_retry struct {
+	delay time.Duration
+}
+
+// Retries with the same delay between each attempt.
+func ConstantRetry(delay time.Duration) RetryPolicy {
+	return constant_retry{delay}
+}
+
+func (r constant_retry) Retry(state RetryState) (time.Duration, bool) {
+	if !Retryable(state) {
+		return 0, false
+	}
+	if state.RetryAfter > r.delay {
+		return state.RetryAfter, true
+	}
+	return r.delay, true
+}
+
+// Reads Retry-After header from response, as either seconds or an HTTP date.
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == NONE {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Decides if attempt (starting at 0) should be retried given err and resp, returns time to wait beforehand.
+func (K *KWAPI) shouldRetry(attempt int, err error, resp *http.Response) (time.Duration, bool) {
+	if attempt >= int(K.Retries) {
+		return 0, false
+	}
+
+	state := RetryState{
+		Attempt:    uint(attempt + 1),
+		Err:        err,
+		RetryAfter: retryAfter(resp),
+	}
+	if resp != nil {
+		state.StatusCode = resp.StatusCode
+	}
+	var e *KWError
+	if errors.As(err, &e) {
+		if state.StatusCode == 0 {
+			state.StatusCode = e.status
+		}
+		if state.RetryAfter == 0 {
+			state.RetryAfter = e.retry_after
+		}
+	}
+
+	policy := K.RetryPolicy
+	if policy == nil {
+		policy = quadratic_retry{}
+	}
+	return policy.Retry(state)
+}
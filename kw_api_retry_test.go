@@ -0,0 +1,102 @@
+package kwlib_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmcoffee/go-kwlib"
+)
+
+// Records the failures it is asked about, retrying those Retryable allows without delay.
+type recordingPolicy struct {
+	mutex  sync.Mutex
+	states []kwlib.RetryState
+}
+
+func (r *recordingPolicy) Retry(state kwlib.RetryState) (time.Duration, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.states = append(r.states, state)
+	return 0, kwlib.Retryable(state)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	srv, s, user := testSession(t)
+	policy := new(recordingPolicy)
+	s.RetryPolicy = policy
+	s.Retries = 2
+
+	srv.Fail("GET", "/rest/folders/", 503, "SERVICE_UNAVAILABLE", 2)
+	if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.states) != 2 {
+		t.Fatalf("policy consulted %d times, expected 2", len(policy.states))
+	}
+	for i, state := range policy.states {
+		if state.Attempt != uint(i+1) || state.StatusCode != 503 || state.Err == nil {
+			t.Fatalf("unexpected state for attempt %d: %+v", i+1, state)
+		}
+	}
+
+	// Attempts stop at Retries regardless of the policy.
+	policy.states = nil
+	srv.Fail("GET", "/rest/folders/", 503, "SERVICE_UNAVAILABLE", 3)
+	if _, err := s.Folders().Get(user.BaseDirID); err == nil {
+		t.Fatal("call succeeded after exhausting retries")
+	}
+	if len(policy.states) != 2 {
+		t.Fatalf("policy consulted %d times, expected 2", len(policy.states))
+	}
+
+	// Failures which are not retryable are returned at once.
+	policy.states = nil
+	if _, err := s.Folders().Get(99999); !kwlib.KWAPIError(err, kwlib.ERR_ENTITY_NOT_FOUND) {
+		t.Fatalf("expected ERR_ENTITY_NOT_FOUND, got %v", err)
+	}
+	if len(policy.states) != 1 {
+		t.Fatalf("policy consulted %d times, expected 1", len(policy.states))
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, test := range []struct {
+		state     kwlib.RetryState
+		retryable bool
+	}{
+		{kwlib.RetryState{Attempt: 1}, true},
+		{kwlib.RetryState{Attempt: 1, StatusCode: 429}, true},
+		{kwlib.RetryState{Attempt: 1, StatusCode: 502}, true},
+		{kwlib.RetryState{Attempt: 1, StatusCode: 400}, false},
+		{kwlib.RetryState{Attempt: 1, StatusCode: 404}, false},
+	} {
+		if kwlib.Retryable(test.state) != test.retryable {
+			t.Errorf("Retryable(%+v) = %t, expected %t", test.state, !test.retryable, test.retryable)
+		}
+	}
+}
+
+func TestExponentialRetry(t *testing.T) {
+	policy := kwlib.ExponentialRetry(100*time.Millisecond, time.Second)
+	for attempt := uint(1); attempt <= 10; attempt++ {
+		ceiling := 100 * time.Millisecond << (attempt - 1)
+		if ceiling > time.Second {
+			ceiling = time.Second
+		}
+		wait, retry := policy.Retry(kwlib.RetryState{Attempt: attempt, StatusCode: 503})
+		if !retry || wait < 0 || wait > ceiling {
+			t.Fatalf("attempt %d waits %s, expected at most %s", attempt, wait, ceiling)
+		}
+	}
+
+	// Retry-After takes precedence over a shorter backoff.
+	wait, _ := policy.Retry(kwlib.RetryState{Attempt: 1, StatusCode: 429, RetryAfter: 5 * time.Second})
+	if wait != 5*time.Second {
+		t.Fatalf("waits %s, expected the 5s requested by Retry-After", wait)
+	}
+
+	if wait, retry := kwlib.ConstantRetry(time.Second).Retry(kwlib.RetryState{Attempt: 3, StatusCode: 500}); !retry || wait != time.Second {
+		t.Fatalf("constant policy waits %s, retry %t", wait, retry)
+	}
+}
@@ -0,0 +1,210 @@
+package kwlib
+
+// Folder operations for a kiteworks session.
+type FolderService struct {
+	session KWSession
+}
+
+// File operations for a kiteworks session.
+type FileService struct {
+	session KWSession
+}
+
+// Access folder operations.
+func (s KWSession) Folders() FolderService {
+	return FolderService{s}
+}
+
+// Access file operations.
+func (s KWSession) Files() FileService {
+	return FileService{s}
+}
+
+// Retrieve folder information.
+func (F FolderService) Get(folder_id int, params ...interface{}) (folder KWFolder, err error) {
+	err = F.session.Call(APIRequest{
+		Method: "GET",
+		Path:   SetPath("/rest/folders/%d", folder_id),
+		Params: SetParams(params),
+		Output: &folder,
+	})
+	return
+}
+
+// Lists top level folders.
+func (F FolderService) Top(params ...interface{}) (folders []KWFolder, err error) {
+	err = F.session.DataCall(APIRequest{
+		Method: "GET",
+		Path:   "/rest/folders/top",
+		Params: SetParams(params),
+		Output: &folders,
+	}, -1, 1000)
+	return
+}
+
+// Lists subfolders and files within a folder.
+func (F FolderService) List(folder_id int, params ...interface{}) (folders []KWFolder, files []KWFile, err error) {
+	err = F.session.DataCall(APIRequest{
+		Method: "GET",
+		Path:   SetPath("/rest/folders/%d/folders", folder_id),
+		Params: SetParams(params),
+		Output: &folders,
+	}, -1, 1000)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = F.session.DataCall(APIRequest{
+		Method: "GET",
+		Path:   SetPath("/rest/folders/%d/files", folder_id),
+		Params: SetParams(params),
+		Output: &files,
+	}, -1, 1000)
+	if err != nil {
+		return nil, nil, err
+	}
+	return
+}
+
+// Lists members of a folder.
+func (F FolderService) Members(folder_id int, params ...interface{}) (members []KWMember, err error) {
+	err = F.session.DataCall(APIRequest{
+		Method: "GET",
+		Path:   SetPath("/rest/folders/%d/members", folder_id),
+		Params: SetParams(Query{"with": "(user,role)"}, params),
+		Output: &members,
+	}, -1, 1000)
+	return
+}
+
+// Creates a new subfolder within parent folder.
+func (F FolderService) Create(parent_id int, name string, params ...interface{}) (folder KWFolder, err error) {
+	err = F.session.Call(APIRequest{
+		Method: "POST",
+		Path:   SetPath("/rest/folders/%d/folders", parent_id),
+		Params: SetParams(PostJSON{"name": name}, Query{"returnEntity": true}, params),
+		Output: &folder,
+	})
+	return
+}
+
+// Deletes folder.
+func (F FolderService) Delete(folder_id int, params ...interface{}) (err error) {
+	return F.session.Call(APIRequest{
+		Method: "DELETE",
+		Path:   SetPath("/rest/folders/%d", folder_id),
+		Params: SetParams(params),
+	})
+}
+
+// Moves folder to destination folder.
+func (F FolderService) Move(folder_id, dest_id int, params ...interface{}) (folder KWFolder, err error) {
+	err = F.session.Call(APIRequest{
+		Method: "POST",
+		Path:   SetPath("/rest/folders/%d/actions/move", folder_id),
+		Params: SetParams(PostJSON{"destinationFolderId": dest_id}, Query{"returnEntity": true}, params),
+		Output: &folder,
+	})
+	return
+}
+
+// Copies folder to destination folder.
+func (F FolderService) Copy(folder_id, dest_id int, params ...interface{}) (folder KWFolder, err error) {
+	err = F.session.Call(APIRequest{
+		Method: "POST",
+		Path:   SetPath("/rest/folders/%d/actions/copy", folder_id),
+		Params: SetParams(PostJSON{"destinationFolderId": dest_id}, Query{"returnEntity": true}, params),
+		Output: &folder,
+	})
+	return
+}
+
+// Retrieve file information.
+func (F FileService) Get(file_id int, params ...interface{}) (file KWFile, err error) {
+	err = F.session.Call(APIRequest{
+		Method: "GET",
+		Path:   SetPath("/rest/files/%d", file_id),
+		Params: SetParams(params),
+		Output: &file,
+	})
+	return
+}
+
+// Deletes file.
+func (F FileService) Delete(file_id int, params ...interface{}) (err error) {
+	return F.session.Call(APIRequest{
+		Method: "DELETE",
+		Path:   SetPath("/rest/files/%d", file_id),
+		Params: SetParams(params),
+	})
+}
+
+// Lists versions of a file.
+func (F FileService) Versions(file_id int, params ...interface{}) (versions []KWFile, err error) {
+	err = F.session.DataCall(APIRequest{
+		Method: "GET",
+		Path:   SetPath("/rest/files/%d/versions", file_id),
+		Params: SetParams(params),
+		Output: &versions,
+	}, -1, 1000)
+	return
+}
+
+// Moves file to destination folder.
+func (F FileService) Move(file_id, dest_id int, params ...interface{}) (file KWFile, err error) {
+	err = F.session.Call(APIRequest{
+		Method: "POST",
+		Path:   SetPath("/rest/files/%d/actions/move", file_id),
+		Params: SetParams(PostJSON{"destinationFolderId": dest_id}, Query{"returnEntity": true}, params),
+		Output: &file,
+	})
+	return
+}
+
+// Copies file to destination folder.
+func (F FileService) Copy(file_id, dest_id int, params ...interface{}) (file KWFile, err error) {
+	err = F.session.Call(APIRequest{
+		Method: "POST",
+		Path:   SetPath("/rest/files/%d/actions/copy", file_id),
+		Params: SetParams(PostJSON{"destinationFolderId": dest_id}, Query{"returnEntity": true}, params),
+		Output: &file,
+	})
+	return
+}
+
+// Deletes folders, returns the outcome for each folder.
+func (F FolderService) DeleteMany(folder_ids []int, params ...interface{}) BatchResult {
+	return F.session.Batch(BatchRequest{
+		Method:   "DELETE",
+		BulkPath: "/rest/folders",
+		ItemPath: "/rest/folders/%d",
+		Params:   SetParams(params),
+	}, folder_ids)
+}
+
+// Moves folders to destination folder, returns the outcome for each folder.
+func (F FolderService) MoveMany(folder_ids []int, dest_id int, params ...interface{}) BatchResult {
+	return F.session.Batch(BatchRequest{
+		Method:   "POST",
+		ItemPath: "/rest/folders/%d/actions/move",
+		Params:   SetParams(PostJSON{"destinationFolderId": dest_id}, params),
+	}, folder_ids)
+}
+
+// Deletes files, returns the outcome for each file.
+func (F FileService) DeleteMany(file_ids []int, params ...interface{}) BatchResult {
+	return F.session.Batch(BatchRequest{
+		Method:   "DELETE",
+		BulkPath: "/rest/files",
+		ItemPath: "/rest/files/%d",
+		Params:   SetParams(params),
+	}, file_ids)
+}
+
+// Moves files to destination folder, returns the outcome for each file.
+func (F FileService) MoveMany(file_ids []int, dest_id int, params ...interface{}) BatchResult {
+	return F.session.Batch(BatchRequest{
+		Method:   "POST",
+		ItemPath: "/rest/files/%d/actions/move",
+		Params:   SetParams(PostJSON{"destinationFolderId": dest_id}, params),
+	}, file_ids)
+}
@@ -0,0 +1,119 @@
+package kwlib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+var ErrStreamTooLarge = fmt.Errorf("Stream exceeds StreamSpoolMax, too large to spool for upload.")
+
+// Holds a stream in memory up to limit bytes, moving it to a temporary file in dir once it grows larger.
+type stream_spool struct {
+	memory bytes.Buffer
+	file   *os.File
+	size   int64
+	limit  int64
+	max    int64 // Max bytes spooled, 0 for no limit.
+	dir    string
+}
+
+// Appends p to the spool.
+func (S *stream_spool) Write(p []byte) (n int, err error) {
+	if S.max > 0 && S.size+int64(len(p)) > S.max {
+		return 0, ErrStreamTooLarge
+	}
+	if S.file == nil && int64(S.memory.Len()+len(p)) > S.limit {
+		if S.file, err = ioutil.TempFile(S.dir, "kwlib-stream-"); err != nil {
+			return 0, err
+		}
+		if _, err = S.memory.WriteTo(S.file); err != nil {
+			return 0, err
+		}
+	}
+	if S.file != nil {
+		n, err = S.file.Write(p)
+	} else {
+		n, err = S.memory.Write(p)
+	}
+	S.size += int64(n)
+	return
+}
+
+// Returns a reader for the spooled stream, from the start.
+func (S *stream_spool) reader() ReadSeekCloser {
+	if S.file != nil {
+		return spool_reader{io.NewSectionReader(S.file, 0, S.size)}
+	}
+	return spool_reader{io.NewSectionReader(bytes.NewReader(S.memory.Bytes()), 0, S.size)}
+}
+
+// Removes the temporary file, if any.
+func (S *stream_spool) Close() error {
+	if S.file == nil {
+		return nil
+	}
+	S.file.Close()
+	return os.Remove(S.file.Name())
+}
+
+// Reader of a stream_spool, which is closed by the spool itself.
+type spool_reader struct {
+	*io.SectionReader
+}
+
+func (spool_reader) Close() error {
+	return nil
+}
+
+// Reader which fails once ctx is done.
+type context_reader struct {
+	ctx context.Context
+	src io.Reader
+}
+
+func (r context_reader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.src.Read(p)
+}
+
+// Uploads src as filename to folder_id, for sources such as pipes where the length is not known in advance.
+func (s KWSession) UploadStream(folder_id int, filename string, src io.Reader) (int, error) {
+	return s.UploadStreamContext(context.Background(), folder_id, filename, src)
+}
+
+// UploadStream bound to ctx, returns the ID of the new file.
+// src is read to the end before the upload is created, held in memory up to a chunk and in a temporary file under StreamSpool beyond that.
+// Streams larger than StreamSpoolMax fail with ErrStreamTooLarge before any upload is created.
+// A stream can not be resumed, so the upload is removed from kiteworks when it fails.
+func (s KWSession) UploadStreamContext(ctx context.Context, folder_id int, filename string, src io.Reader) (file_id int, err error) {
+	spool := &stream_spool{limit: s.chunkSize(), max: s.StreamSpoolMax, dir: s.StreamSpool}
+	defer spool.Close()
+
+	if _, err := io.Copy(spool, context_reader{ctx, src}); err != nil {
+		return -1, err
+	}
+
+	upload_id, err := s.NewUpload(folder_id, filename, spool.size)
+	if err != nil {
+		return -1, err
+	}
+
+	// The spool is gone by the time the plan is replayed.
+	if s.plan != nil {
+		return s.planUpload(filename, upload_id, nil), nil
+	}
+
+	defer func() {
+		if err != nil {
+			s.cancelUpload(upload_id)
+		}
+	}()
+
+	return s.UploadContext(ctx, filename, upload_id, spool.reader())
+}
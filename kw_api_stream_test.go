@@ -0,0 +1,124 @@
+package kwlib_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cmcoffee/go-kwlib"
+)
+
+// Hides all but Read, as a pipe would.
+type onlyReader struct {
+	io.Reader
+}
+
+func TestUploadStream(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	// Records the totalSize of each upload initiated.
+	var (
+		lock  sync.Mutex
+		sizes []int64
+	)
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/actions/initiateUpload") {
+			body, _ := ioutil.ReadAll(r.Body)
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			var input struct {
+				TotalSize int64 `json:"totalSize"`
+			}
+			json.Unmarshal(body, &input)
+			lock.Lock()
+			sizes = append(sizes, input.TotalSize)
+			lock.Unlock()
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer plain.Close()
+	s.BaseURL = plain.URL
+
+	spool := t.TempDir()
+	s.StreamSpool = spool
+
+	for i, size := range []int{0, 10, 1 << 20, 3<<20 + 17} {
+		content := bytes.Repeat([]byte{byte('a' + i)}, size)
+		name := fmt.Sprintf("%d.dump", size)
+		file_id, err := s.UploadStream(user.BaseDirID, name, onlyReader{bytes.NewReader(content)})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		checkContent(t, srv, file_id, content)
+		if sizes[len(sizes)-1] != int64(size) {
+			t.Fatalf("%s: initiated with totalSize %d", name, sizes[len(sizes)-1])
+		}
+		if files, _ := ioutil.ReadDir(spool); len(files) != 0 {
+			t.Fatalf("%s: spool left behind in %s", name, spool)
+		}
+	}
+	if n := pendingUploads(t, s); n != 0 {
+		t.Fatalf("%d uploads left open", n)
+	}
+
+	// Chunks are retried from the spool.
+	s.Retries = 2
+	srv.Fail("POST", "/rest/uploads/", 503, "SERVICE_UNAVAILABLE", 1)
+	content := bytes.Repeat([]byte("r"), 2<<20+3)
+	file_id, err := s.UploadStream(user.BaseDirID, "retry.dump", onlyReader{bytes.NewReader(content)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, srv, file_id, content)
+
+	// A failed stream removes its upload.
+	s.Retries = 0
+	srv.Fail("POST", "/rest/uploads/", 422, "ERR_INPUT_INVALID", 1)
+	if _, err := s.UploadStream(user.BaseDirID, "failed.dump", onlyReader{strings.NewReader("x")}); err == nil {
+		t.Fatal("expected an error from a failing chunk")
+	}
+	if n := pendingUploads(t, s); n != 0 {
+		t.Fatalf("failed upload left open, %d pending", n)
+	}
+
+	// Streams are planned with their size.
+	var plan kwlib.Plan
+	p := s.PlanMode(&plan)
+	if file_id, err := p.UploadStream(user.BaseDirID, "planned.dump", strings.NewReader("planned")); err != nil || file_id >= 0 || plan.Len() != 2 {
+		t.Fatalf("planned file %d, %v", file_id, err)
+	}
+	if step := plan.Steps()[0]; fmt.Sprint(step.JSON["totalSize"]) != "7" {
+		t.Fatalf("planned with totalSize %v", step.JSON["totalSize"])
+	}
+}
+
+func TestUploadStreamMax(t *testing.T) {
+	_, s, user := testSession(t)
+
+	spool := t.TempDir()
+	s.StreamSpool = spool
+	s.StreamSpoolMax = 2 << 20
+
+	content := bytes.Repeat([]byte("m"), 2<<20+1)
+	if _, err := s.UploadStream(user.BaseDirID, "large.dump", onlyReader{bytes.NewReader(content)}); !errors.Is(err, kwlib.ErrStreamTooLarge) {
+		t.Fatalf("expected ErrStreamTooLarge, got %v", err)
+	}
+	if files, _ := ioutil.ReadDir(spool); len(files) != 0 {
+		t.Fatalf("spool left behind in %s", spool)
+	}
+	if n := pendingUploads(t, s); n != 0 {
+		t.Fatalf("%d uploads created for a stream too large", n)
+	}
+
+	// Streams of StreamSpoolMax are still uploaded.
+	if _, err := s.UploadStream(user.BaseDirID, "max.dump", onlyReader{bytes.NewReader(content[1:])}); err != nil {
+		t.Fatal(err)
+	}
+}
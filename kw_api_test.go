@@ -0,0 +1,283 @@
+package kwlib_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cmcoffee/go-kwlib"
+	"github.com/cmcoffee/go-kwlib/kwlibtest"
+)
+
+// Starts a fake kiteworks with a single user, returning a session authenticated as that user.
+func testSession(tb testing.TB) (*kwlibtest.Server, *kwlib.KWSession, kwlib.KWUser) {
+	srv := kwlibtest.NewServer()
+	tb.Cleanup(srv.Close)
+	srv.SetSignature("signature_key")
+	user := srv.AddUser("user@example.com", "password")
+	K := srv.KWAPI()
+	K.MaxChunkSize = 1 << 20
+	s, err := K.Authenticate(user.Email)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return srv, s, user
+}
+
+func TestAuthenticate(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	var me kwlib.KWUser
+	if err := s.Call(kwlib.APIRequest{Method: "GET", Path: "/rest/users/me", Output: &me}); err != nil {
+		t.Fatal(err)
+	}
+	if me.ID != user.ID || me.Email != user.Email {
+		t.Fatalf("got user %d %s, expected %d %s", me.ID, me.Email, user.ID, user.Email)
+	}
+
+	// Expired tokens are renewed with the refresh token.
+	srv.ExpireTokens()
+	if err := s.Call(kwlib.APIRequest{Method: "GET", Path: "/rest/users/me", Output: &me}); err != nil {
+		t.Fatalf("after token expiry: %s", err)
+	}
+
+	K := srv.KWAPI()
+	K.Signature("wrong_key")
+	if _, err := K.Authenticate(user.Email); err == nil {
+		t.Fatal("authenticated with the wrong signature key")
+	}
+}
+
+func TestCall(t *testing.T) {
+	_, s, user := testSession(t)
+
+	var folder kwlib.KWFolder
+	err := s.Call(kwlib.APIRequest{
+		Method: "POST",
+		Path:   kwlib.SetPath("/rest/folders/%d/folders", user.BaseDirID),
+		Params: kwlib.SetParams(kwlib.PostJSON{"name": "Reports"}, kwlib.Query{"returnEntity": true}),
+		Output: &folder,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder.ID == 0 || folder.Name != "Reports" || folder.ParentID != user.BaseDirID {
+		t.Fatalf("unexpected folder %+v", folder)
+	}
+
+	if _, err := s.Folders().Create(user.BaseDirID, "Reports"); !kwlib.KWAPIError(err, kwlib.ERR_ENTITY_EXISTS) {
+		t.Fatalf("expected ERR_ENTITY_EXISTS, got %v", err)
+	}
+	if _, err := s.Files().Get(99999); !kwlib.KWAPIError(err, kwlib.ERR_ENTITY_NOT_FOUND) {
+		t.Fatalf("expected ERR_ENTITY_NOT_FOUND, got %v", err)
+	}
+}
+
+func TestDataCall(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		srv.AddFolder(user.BaseDirID, name)
+	}
+
+	var folders []kwlib.KWFolder
+	err := s.DataCall(kwlib.APIRequest{
+		Method: "GET",
+		Path:   kwlib.SetPath("/rest/folders/%d/folders", user.BaseDirID),
+		Output: &folders,
+	}, -1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != 5 {
+		t.Fatalf("got %d folders over pages of 2, expected 5", len(folders))
+	}
+
+	folders = nil
+	err = s.DataCall(kwlib.APIRequest{
+		Method: "GET",
+		Path:   kwlib.SetPath("/rest/folders/%d/folders", user.BaseDirID),
+		Output: &folders,
+	}, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != 2 || folders[0].Name != "c" || folders[1].Name != "d" {
+		t.Fatalf("offset 2, limit 2 returned %+v", folders)
+	}
+}
+
+func TestDataCallParallel(t *testing.T) {
+	srv, s, user := testSession(t)
+	s.PageThreads = 4
+	s.SetLimiter(2)
+
+	for i := 0; i < 47; i++ {
+		srv.AddFolder(user.BaseDirID, fmt.Sprintf("folder%02d", i))
+	}
+
+	params := kwlib.SetParams(kwlib.Query{"deleted": false})
+	var folders []kwlib.KWFolder
+	err := s.DataCall(kwlib.APIRequest{
+		Method: "GET",
+		Path:   kwlib.SetPath("/rest/folders/%d/folders", user.BaseDirID),
+		Params: params,
+		Output: &folders,
+	}, -1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != 47 {
+		t.Fatalf("got %d folders over pages of 5, expected 47", len(folders))
+	}
+	for i, f := range folders {
+		if f.Name != fmt.Sprintf("folder%02d", i) {
+			t.Fatalf("folder %d is %s, pages out of order", i, f.Name)
+		}
+	}
+	if len(params) != 1 {
+		t.Fatalf("caller params modified: %v", params)
+	}
+
+	// A failing page following the first fails the call.
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("offset") == "20" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":[{"code":"ERR_INPUT_INVALID","message":"Bad page"}]}`))
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer plain.Close()
+	s.BaseURL = plain.URL
+	s.Retries = 0
+
+	err = s.DataCall(kwlib.APIRequest{
+		Method: "GET",
+		Path:   kwlib.SetPath("/rest/folders/%d/folders", user.BaseDirID),
+		Output: &folders,
+	}, -1, 5)
+	if !errors.Is(err, kwlib.Error("ERR_INPUT_INVALID")) {
+		t.Fatalf("expected ERR_INPUT_INVALID, got %v", err)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	srv := kwlibtest.NewServer()
+	defer srv.Close()
+	srv.Prefix = "/kiteworks"
+	srv.SetSignature("signature_key")
+	user := srv.AddUser("user@example.com", "password")
+	file := srv.AddFile(user.BaseDirID, "hello.txt", []byte("hello world"))
+
+	// Serve the same fake over plain HTTP.
+	plain := httptest.NewServer(srv.Config.Handler)
+	defer plain.Close()
+
+	K := srv.KWAPI()
+	K.BaseURL = plain.URL + "/kiteworks/"
+
+	s, err := K.Authenticate(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Files().Get(file.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := s.NewRequest("GET", kwlib.SetPath("/rest/files/%d/content", file.ID), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := plain.URL + kwlib.SetPath("/kiteworks/rest/files/%d/content", file.ID); req.URL.String() != expected {
+		t.Fatalf("request URL is %s, expected %s", req.URL, expected)
+	}
+
+	dl := s.Download(req)
+	defer dl.Close()
+	content, err := ioutil.ReadAll(dl)
+	if err != nil || string(content) != "hello world" {
+		t.Fatalf("downloaded %q, %v", content, err)
+	}
+
+	K.BaseURL = "kiteworks.example.com"
+	if _, err := s.NewRequest("GET", "/rest/users/me", 0); err == nil {
+		t.Fatal("BaseURL without a scheme was accepted")
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	_, s, user := testSession(t)
+
+	// A bad setting is returned to the caller rather than ending the program.
+	s.Close()
+	s.ProxyURI = "http://proxy.example.com:port"
+	if _, err := s.TryNewClient(); err == nil {
+		t.Fatal("client built with an invalid ProxyURI")
+	}
+	if _, err := s.Folders().Get(user.BaseDirID); err == nil {
+		t.Fatal("call succeeded with an invalid ProxyURI")
+	}
+
+	s.ProxyURI = kwlib.NONE
+	if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	var connections int32
+	plain := httptest.NewUnstartedServer(srv.Config.Handler)
+	plain.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	plain.Start()
+	defer plain.Close()
+	s.BaseURL = plain.URL
+
+	// Error responses are consumed, so they do not cost the connection either.
+	for i := 0; i < 5; i++ {
+		if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Files().Get(99999); err == nil {
+			t.Fatal("found a file which does not exist")
+		}
+	}
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Fatalf("10 sequential calls opened %d connections, expected 1", n)
+	}
+}
+
+// Calls reuse keep-alive connections of the transport shared by the KWAPI.
+func BenchmarkSharedTransport(b *testing.B) {
+	_, s, user := testSession(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Each call builds a new transport, connecting and completing a TLS handshake each time.
+func BenchmarkPerRequestTransport(b *testing.B) {
+	_, s, user := testSession(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Close()
+		if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
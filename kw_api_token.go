@@ -2,11 +2,13 @@ package kwlib
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
@@ -36,7 +38,7 @@ func (K *KWAPI) authenticate(username string, permit_change, auth_loop bool) (*K
 		if token != nil {
 			if token.Expires < time.Now().Add(time.Duration(5*time.Minute)).Unix() {
 				// First attempt to use a refresh token if there is one.
-				token, err = K.refreshToken(username, token)
+				token, err = K.refreshToken(context.Background(), username, token)
 				if err != nil {
 					if K.secrets.signature_key == nil {
 						Notice("Unable to use refresh token, must reauthenticate for new access token: %s", err.Error())
@@ -57,7 +59,7 @@ func (K *KWAPI) authenticate(username string, permit_change, auth_loop bool) (*K
 	var report_success bool
 
 	if K.secrets.signature_key == nil {
-		Stdout("--- %s authentication ---\n\n", K.Server)
+		Stdout("--- %s authentication ---\n\n", K.hostname())
 		for {
 			if username == NONE {
 				username = strings.ToLower(GetInput("-> username: "))
@@ -79,7 +81,7 @@ func (K *KWAPI) authenticate(username string, permit_change, auth_loop bool) (*K
 				continue
 			}
 
-			auth, err := K.newToken(username, password)
+			auth, err := K.newToken(context.Background(), username, password)
 			if err != nil {
 				if permit_change {
 					username = NONE
@@ -96,14 +98,14 @@ func (K *KWAPI) authenticate(username string, permit_change, auth_loop bool) (*K
 					return &session, err
 				} else {
 					if report_success {
-						Stdout("\n<- %s reports success!\n\n", K.Server)
+						Stdout("\n<- %s reports success!\n\n", K.hostname())
 					}
 				}
 				return &session, nil
 			}
 		}
 	} else {
-		auth, err := K.newToken(username, NONE)
+		auth, err := K.newToken(context.Background(), username, NONE)
 		if err != nil {
 			return nil, err
 		}
@@ -130,7 +132,7 @@ func (s KWSession) setToken(req *http.Request, clear bool) (err error) {
 	if token != nil && !clear {
 		if token.Expires < time.Now().Add(time.Duration(5*time.Minute)).Unix() {
 			// First attempt to use a refresh token if there is one.
-			token, err = s.refreshToken(s.Username, token)
+			token, err = s.refreshToken(req.Context(), s.Username, token)
 			if err != nil && s.secrets.signature_key == nil {
 				Notice("Unable to use refresh token, must reauthenticate for new access token: %s", err.Error())
 			}
@@ -144,7 +146,7 @@ func (s KWSession) setToken(req *http.Request, clear bool) (err error) {
 
 	if token == nil {
 		if s.secrets.signature_key != nil {
-			token, err = s.newToken(s.Username, NONE)
+			token, err = s.newToken(req.Context(), s.Username, NONE)
 			if err != nil {
 				return err
 			}
@@ -169,14 +171,84 @@ func (s KWSession) setToken(req *http.Request, clear bool) (err error) {
 	return nil
 }
 
+// Replaces the stored token after orig_err was returned by kiteworks, refreshing it when possible.
+func (s KWSession) renewToken(ctx context.Context, orig_err error) error {
+	s.testTokenStore()
+
+	if s.secrets.signature_key == nil {
+		existing, err := s.TokenStore.Load(s.Username)
+		if err != nil {
+			return err
+		}
+		if token, err := s.refreshToken(ctx, s.Username, existing); err == nil {
+			return s.TokenStore.Save(s.Username, token)
+		}
+		s.TokenStore.Delete(s.Username)
+		return fmt.Errorf("Token is no longer valid: %s", orig_err.Error())
+	}
+
+	if !KWAPIError(orig_err, TOKEN_ERR) {
+		return nil
+	}
+
+	s.TokenStore.Delete(s.Username)
+	token, err := s.newToken(ctx, s.Username, NONE)
+	if err != nil {
+		return err
+	}
+	return s.TokenStore.Save(s.Username, token)
+}
+
+// Sends request to the OAuth token endpoint, retrying failures according to RetryPolicy.
+func (K *KWAPI) postToken(ctx context.Context, username string, req *http.Request, body []byte) (resp *http.Response, err error) {
+	client, err := K.Session(username).TryNewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	for i := 0; ; i++ {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		resp, err = client.Do(req.WithContext(withAttempt(ctx, i+1)))
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// Rejected grants will not succeed on another attempt.
+		if KWAPIError(err, TOKEN_ERR) {
+			return nil, err
+		}
+		wait, retry := K.shouldRetry(i, err, resp)
+		if !retry {
+			return nil, err
+		}
+		Debug("(TOKEN ERROR) %s: %s (%d/%d)", username, err.Error(), i+1, K.Retries+1)
+		K.Session(username).traceRetry(req.Method, req.URL.Path, i+1, err, wait)
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
 // Get a new token from a refresh token.
-func (K *KWAPI) refreshToken(username string, auth *KWAuth) (*KWAuth, error) {
+func (K *KWAPI) refreshToken(ctx context.Context, username string, auth *KWAuth) (_ *KWAuth, err error) {
 	if auth == nil {
 		return nil, fmt.Errorf("No refresh token found for %s.", username)
 	}
-	path := fmt.Sprintf("https://%s/oauth/token", K.Server)
+	start := time.Now()
+	defer func() { K.traceToken(username, "refresh_token", start, err) }()
+
+	path, err := K.endpoint("/oauth/token")
+	if err != nil {
+		return nil, err
+	}
 
-	req, err := http.NewRequest(http.MethodPost, path, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -199,22 +271,7 @@ func (K *KWAPI) refreshToken(username string, auth *KWAuth) (*KWAuth, error) {
 		"refresh_token": {auth.RefreshToken},
 	}
 
-	if K.Snoop {
-		Stdout("\n[kiteworks]: %s\n--> ACTION: \"POST\" PATH: \"%s\"", username, path)
-		for k, v := range *postform {
-			if k == "grant_type" || k == "RedirectURI" || k == "scope" {
-				Stdout("\\-> POST PARAM: %s VALUE: %s", k, v)
-			} else {
-				Stdout("\\-> POST PARAM: %s VALUE: [HIDDEN]", k)
-			}
-		}
-	}
-
-	req.Body = ioutil.NopCloser(bytes.NewReader([]byte(postform.Encode())))
-
-	client := K.Session(username).NewClient()
-
-	resp, err := client.Do(req)
+	resp, err := K.postToken(ctx, username, req, []byte(postform.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -228,11 +285,20 @@ func (K *KWAPI) refreshToken(username string, auth *KWAuth) (*KWAuth, error) {
 }
 
 // Generate a new Bearer token from kiteworks.
-func (K *KWAPI) newToken(username, password string) (auth *KWAuth, err error) {
+func (K *KWAPI) newToken(ctx context.Context, username, password string) (auth *KWAuth, err error) {
+	grant := "authorization_code"
+	if password != NONE {
+		grant = "password"
+	}
+	start := time.Now()
+	defer func() { K.traceToken(username, grant, start, err) }()
 
-	path := fmt.Sprintf("https://%s/oauth/token", K.Server)
+	path, err := K.endpoint("/oauth/token")
+	if err != nil {
+		return nil, err
+	}
 
-	req, err := http.NewRequest(http.MethodPost, path, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -252,7 +318,7 @@ func (K *KWAPI) newToken(username, password string) (auth *KWAuth, err error) {
 	}
 
 	if password != NONE {
-		postform.Add("grant_type", "password")
+		postform.Add("grant_type", grant)
 		postform.Add("username", username)
 		postform.Add("password", password)
 	} else {
@@ -272,27 +338,12 @@ func (K *KWAPI) newToken(username, password string) (auth *KWAuth, err error) {
 			base64.StdEncoding.EncodeToString([]byte(username)),
 			timestamp, nonce, signature)
 
-		postform.Add("grant_type", "authorization_code")
+		postform.Add("grant_type", grant)
 		postform.Add("code", auth_code)
 
 	}
 
-	if K.Snoop {
-		Stdout("\n[kiteworks]: %s\n--> ACTION: \"POST\" PATH: \"%s\"", username, path)
-		for k, v := range *postform {
-			if k == "grant_type" || k == "redirect_uri" || k == "scope" {
-				Stdout("\\-> POST PARAM: %s VALUE: %s", k, v)
-			} else {
-				Stdout("\\-> POST PARAM: %s VALUE: [HIDDEN]", k)
-			}
-		}
-	}
-
-	req.Body = ioutil.NopCloser(bytes.NewReader([]byte(postform.Encode())))
-
-	client := K.Session(username).NewClient()
-
-	resp, err := client.Do(req)
+	resp, err := K.postToken(ctx, username, req, []byte(postform.Encode()))
 	if err != nil {
 		return nil, err
 	}
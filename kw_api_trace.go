@@ -0,0 +1,366 @@
+package kwlib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request about to be sent to kiteworks.
+type TraceRequest struct {
+	Username string // User of the session, redacted by KWAPI.Redactor.
+	Method   string
+	Path     string
+	Query    url.Values
+	Header   http.Header // Request headers, redacted by KWAPI.Redactor.
+	Body     []byte      // Request body redacted by KWAPI.Redactor, nil for streamed bodies such as upload chunks.
+	Attempt  uint        // Attempt number, starting at 1.
+}
+
+// Response received from kiteworks, or the failure to receive one.
+type TraceResponse struct {
+	Username   string // User of the session, redacted by KWAPI.Redactor.
+	Method     string
+	Path       string
+	Attempt    uint
+	Status     int    // HTTP status code, 0 if no response was received.
+	StatusText string // HTTP status line, ie.. "200 OK".
+	Header     http.Header
+	Body       []byte        // Response body redacted by KWAPI.Redactor, only captured for JSON and error responses.
+	Duration   time.Duration // Time taken to receive the response headers.
+	Err        error         // Error when no response was received.
+}
+
+// Failed attempt which is about to be retried.
+type TraceRetry struct {
+	Username string // User of the session, redacted by KWAPI.Redactor.
+	Method   string
+	Path     string
+	Attempt  uint          // Attempt which failed, starting at 1.
+	Err      error         // Error returned by the attempt.
+	Wait     time.Duration // Delay before the next attempt.
+}
+
+// Request for a new access token.
+type TraceToken struct {
+	Username string // User of the session, redacted by KWAPI.Redactor.
+	Grant    string // OAuth grant type used, ie.. refresh_token.
+	Duration time.Duration
+	Err      error
+}
+
+// Tracer receives details of every request made to kiteworks, set KWAPI.Tracer to enable.
+// Callbacks may be made concurrently from multiple goroutines.
+type Tracer interface {
+	OnRequest(req TraceRequest)
+	OnResponse(resp TraceResponse)
+	OnRetry(retry TraceRetry)
+	OnTokenRefresh(token TraceToken)
+}
+
+// Returns configured tracer, the snoop tracer when only Snoop is set, or nil when tracing is disabled.
+func (K *KWAPI) tracer() Tracer {
+	if K.Tracer != nil {
+		return K.Tracer
+	}
+	if K.Snoop {
+		return snoop_tracer{}
+	}
+	return nil
+}
+
+type trace_attempt_key struct{}
+
+// Records attempt number on ctx for requests sent with it.
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, trace_attempt_key{}, uint(attempt))
+}
+
+// Returns the attempt number recorded on ctx, defaults to 1.
+func attemptOf(ctx context.Context) uint {
+	if attempt, ok := ctx.Value(trace_attempt_key{}).(uint); ok {
+		return attempt
+	}
+	return 1
+}
+
+// Sends req, reporting the request and its response to the tracer.
+func (c *KWAPIClient) do(req *http.Request) (*http.Response, error) {
+	tracer := c.session.tracer()
+	if tracer == nil {
+		if c.session.Metrics == nil {
+			return c.Client.Do(req)
+		}
+		start := time.Now()
+		resp, err := c.Client.Do(req)
+		c.session.metricRequest(req.Method, req.URL.Path, resp, time.Since(start))
+		return resp, err
+	}
+
+	attempt := attemptOf(req.Context())
+	redactor := c.session.redactor()
+	path := redactor.String(req.URL.Path)
+
+	username := redactor.String(c.session.Username)
+
+	trace_req := TraceRequest{
+		Username: username,
+		Method:   req.Method,
+		Path:     path,
+		Query:    redactor.Values(req.URL.Query()),
+		Header:   redactor.Header(req.Header),
+		Attempt:  attempt,
+	}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := ioutil.ReadAll(body)
+			body.Close()
+			trace_req.Body = redactor.Body(req.Header.Get("Content-Type"), data)
+		}
+	}
+	tracer.OnRequest(trace_req)
+
+	start := time.Now()
+	resp, err := c.Client.Do(req)
+	c.session.metricRequest(req.Method, req.URL.Path, resp, time.Since(start))
+
+	trace_resp := TraceResponse{
+		Username: username,
+		Method:   req.Method,
+		Path:     path,
+		Attempt:  attempt,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+
+	if resp != nil {
+		trace_resp.Status = resp.StatusCode
+		trace_resp.StatusText = resp.Status
+		trace_resp.Header = redactor.Header(resp.Header)
+
+		content_type := resp.Header.Get("Content-Type")
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 || strings.Contains(content_type, "json") {
+			data, read_err := ioutil.ReadAll(io.LimitReader(resp.Body, trace_body_max))
+			resp.Body = &trace_body{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+			if read_err != nil {
+				trace_resp.Err = read_err
+			}
+			trace_resp.Body = redactor.Body(content_type, data)
+		}
+	}
+
+	tracer.OnResponse(trace_resp)
+	return resp, err
+}
+
+// Largest response body captured for tracing.
+const trace_body_max = 1 << 20
+
+// Replays the captured portion of a response body before the remainder.
+type trace_body struct {
+	io.Reader
+	io.Closer
+}
+
+// Reports a retry to the tracer and metrics.
+func (s KWSession) traceRetry(method, path string, attempt int, err error, wait time.Duration) {
+	s.metricAdd(METRIC_RETRIES, Labels{"method": strings.ToUpper(method), "path": pathTemplate(path)}, 1)
+	if tracer := s.tracer(); tracer != nil {
+		tracer.OnRetry(TraceRetry{
+			Username: s.redactor().String(s.Username),
+			Method:   strings.ToUpper(method),
+			Path:     s.redactor().String(path),
+			Attempt:  uint(attempt),
+			Err:      err,
+			Wait:     wait,
+		})
+	}
+}
+
+// Reports a token request to the tracer and metrics.
+func (K *KWAPI) traceToken(username, grant string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	K.metricAdd(METRIC_TOKEN_REQUESTS, Labels{"grant": grant, "result": result}, 1)
+	if tracer := K.tracer(); tracer != nil {
+		tracer.OnTokenRefresh(TraceToken{
+			Username: K.redactor().String(username),
+			Grant:    grant,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+	}
+}
+
+// Tracer which writes calls to the Snoop log, used when KWAPI.Snoop is set.
+type snoop_tracer struct{}
+
+// Returns tracer which writes requests and responses to the Snoop log.
+func SnoopTracer() Tracer {
+	return snoop_tracer{}
+}
+
+func (t snoop_tracer) OnRequest(req TraceRequest) {
+	Snoop("[kiteworks snoop]: %s", req.Username)
+	Snoop("--> METHOD: \"%s\" PATH: \"%s\"", req.Method, req.Path)
+	for k, v := range req.Query {
+		Snoop("\\-> QUERY: %s=%s", k, v)
+	}
+	content_type := req.Header.Get("Content-Type")
+	switch {
+	case len(req.Body) == 0:
+		return
+	case strings.Contains(content_type, "x-www-form-urlencoded"):
+		values, _ := url.ParseQuery(string(req.Body))
+		for k, v := range values {
+			Snoop("\\-> POST PARAM: \"%s\" VALUE: \"%s\"", k, v)
+		}
+	case strings.Contains(content_type, "json"):
+		Snoop("\\-> POST JSON: %s", string(req.Body))
+	}
+}
+
+func (t snoop_tracer) OnResponse(resp TraceResponse) {
+	if resp.Status == 0 {
+		if resp.Err != nil {
+			Snoop("<-- ERROR: %s", resp.Err.Error())
+		}
+		return
+	}
+	Snoop("<-- RESPONSE STATUS: %s", resp.StatusText)
+	if len(resp.Body) == 0 {
+		return
+	}
+	var buffer bytes.Buffer
+	if err := json.Indent(&buffer, resp.Body, "", "  "); err != nil {
+		Snoop("%s", string(resp.Body))
+		return
+	}
+	Snoop("<-- RESPONSE BODY: \n%s\n", buffer.String())
+}
+
+func (t snoop_tracer) OnRetry(retry TraceRetry) {}
+
+func (t snoop_tracer) OnTokenRefresh(token TraceToken) {}
+
+// Tracer which writes each event as a line of JSON.
+type json_tracer struct {
+	mutex  sync.Mutex
+	output io.Writer
+}
+
+// Returns tracer which writes each event to w as a single line of JSON.
+func JSONTracer(w io.Writer) Tracer {
+	return &json_tracer{output: w}
+}
+
+type json_trace_event struct {
+	Time     time.Time   `json:"time"`
+	Event    string      `json:"event"`
+	Username string      `json:"username,omitempty"`
+	Method   string      `json:"method,omitempty"`
+	Path     string      `json:"path,omitempty"`
+	Query    url.Values  `json:"query,omitempty"`
+	Attempt  uint        `json:"attempt,omitempty"`
+	Status   int         `json:"status,omitempty"`
+	Duration float64     `json:"duration_ms,omitempty"`
+	Wait     float64     `json:"wait_ms,omitempty"`
+	Grant    string      `json:"grant,omitempty"`
+	Header   http.Header `json:"header,omitempty"`
+	Body     interface{} `json:"body,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// Embeds body as JSON when possible, otherwise as a string.
+func json_trace_body(body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	return string(body)
+}
+
+func json_trace_error(err error) string {
+	if err == nil {
+		return NONE
+	}
+	return err.Error()
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (t *json_tracer) write(event json_trace_event) {
+	event.Time = time.Now().UTC()
+	output, err := json.Marshal(&event)
+	if err != nil {
+		output, _ = json.Marshal(&json_trace_event{Time: event.Time, Event: event.Event, Error: fmt.Sprintf("Unable to encode trace: %s", err.Error())})
+	}
+	output = append(output, '\n')
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.output.Write(output)
+}
+
+func (t *json_tracer) OnRequest(req TraceRequest) {
+	t.write(json_trace_event{
+		Event:    "request",
+		Username: req.Username,
+		Method:   req.Method,
+		Path:     req.Path,
+		Query:    req.Query,
+		Attempt:  req.Attempt,
+		Header:   req.Header,
+		Body:     json_trace_body(req.Body),
+	})
+}
+
+func (t *json_tracer) OnResponse(resp TraceResponse) {
+	t.write(json_trace_event{
+		Event:    "response",
+		Username: resp.Username,
+		Method:   resp.Method,
+		Path:     resp.Path,
+		Attempt:  resp.Attempt,
+		Status:   resp.Status,
+		Duration: milliseconds(resp.Duration),
+		Header:   resp.Header,
+		Body:     json_trace_body(resp.Body),
+		Error:    json_trace_error(resp.Err),
+	})
+}
+
+func (t *json_tracer) OnRetry(retry TraceRetry) {
+	t.write(json_trace_event{
+		Event:    "retry",
+		Username: retry.Username,
+		Method:   retry.Method,
+		Path:     retry.Path,
+		Attempt:  retry.Attempt,
+		Wait:     milliseconds(retry.Wait),
+		Error:    json_trace_error(retry.Err),
+	})
+}
+
+func (t *json_tracer) OnTokenRefresh(token TraceToken) {
+	t.write(json_trace_event{
+		Event:    "token",
+		Username: token.Username,
+		Grant:    token.Grant,
+		Duration: milliseconds(token.Duration),
+		Error:    json_trace_error(token.Err),
+	})
+}
@@ -0,0 +1,142 @@
+package kwlib_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cmcoffee/go-kwlib"
+	"github.com/cmcoffee/go-kwlib/kwlibtest"
+)
+
+// Keeps every event traced.
+type recordingTracer struct {
+	mutex     sync.Mutex
+	requests  []kwlib.TraceRequest
+	responses []kwlib.TraceResponse
+	retries   []kwlib.TraceRetry
+	tokens    []kwlib.TraceToken
+}
+
+func (r *recordingTracer) OnRequest(req kwlib.TraceRequest) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, req)
+}
+
+func (r *recordingTracer) OnResponse(resp kwlib.TraceResponse) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.responses = append(r.responses, resp)
+}
+
+func (r *recordingTracer) OnRetry(retry kwlib.TraceRetry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.retries = append(r.retries, retry)
+}
+
+func (r *recordingTracer) OnTokenRefresh(token kwlib.TraceToken) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tokens = append(r.tokens, token)
+}
+
+// Starts a fake kiteworks, returning a session authenticated with tracer set.
+func tracedSession(t *testing.T, tracer kwlib.Tracer) (*kwlibtest.Server, *kwlib.KWSession, kwlib.KWUser) {
+	srv := kwlibtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.SetSignature("signature_key")
+	user := srv.AddUser("user@example.com", "password")
+	K := srv.KWAPI()
+	K.Tracer = tracer
+	K.RetryPolicy = kwlib.ConstantRetry(0)
+	s, err := K.Authenticate(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, s, user
+}
+
+func TestTracer(t *testing.T) {
+	tracer := new(recordingTracer)
+	srv, s, user := tracedSession(t, tracer)
+
+	if len(tracer.tokens) != 1 || tracer.tokens[0].Grant != "authorization_code" || tracer.tokens[0].Err != nil {
+		t.Fatalf("unexpected token events %+v", tracer.tokens)
+	}
+
+	tracer.requests, tracer.responses = nil, nil
+
+	srv.Fail("GET", "/rest/folders/", 503, "SERVICE_UNAVAILABLE", 1)
+	if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tracer.requests) != 2 || len(tracer.responses) != 2 || len(tracer.retries) != 1 {
+		t.Fatalf("traced %d requests, %d responses and %d retries, expected 2, 2 and 1", len(tracer.requests), len(tracer.responses), len(tracer.retries))
+	}
+	for i, req := range tracer.requests {
+		if req.Method != "GET" || !strings.HasPrefix(req.Path, "/rest/folders/") || req.Attempt != uint(i+1) {
+			t.Fatalf("unexpected request %+v", req)
+		}
+		if auth := req.Header.Get("Authorization"); auth != "[HIDDEN]" {
+			t.Fatalf("Authorization traced as %q", auth)
+		}
+	}
+	if resp := tracer.responses[0]; resp.Status != 503 || resp.Attempt != 1 || !strings.Contains(string(resp.Body), "SERVICE_UNAVAILABLE") {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if resp := tracer.responses[1]; resp.Status != 200 || resp.Attempt != 2 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if retry := tracer.retries[0]; retry.Attempt != 1 || retry.Err == nil {
+		t.Fatalf("unexpected retry %+v", retry)
+	}
+
+	// Renewal of an expired token is traced.
+	srv.ExpireTokens()
+	if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracer.tokens) != 2 || tracer.tokens[1].Err != nil {
+		t.Fatalf("unexpected token events %+v", tracer.tokens)
+	}
+}
+
+func TestJSONTracer(t *testing.T) {
+	var output bytes.Buffer
+	srv, s, user := tracedSession(t, kwlib.JSONTracer(&output))
+
+	srv.Fail("GET", "/rest/folders/", 503, "SERVICE_UNAVAILABLE", 1)
+	if _, err := s.Folders().Get(user.BaseDirID); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(map[string]int)
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		var event struct {
+			Event string `json:"event"`
+			Path  string `json:"path"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("%s: %s", scanner.Text(), err)
+		}
+		if event.Event == "token" || strings.HasPrefix(event.Path, "/rest/folders/") {
+			events[event.Event]++
+		}
+		if strings.Contains(scanner.Text(), "kwlibtest_secret") || strings.Contains(scanner.Text(), "Bearer ") {
+			t.Fatalf("credentials traced: %s", scanner.Text())
+		}
+	}
+
+	for event, count := range map[string]int{"token": 1, "request": 2, "response": 2, "retry": 1} {
+		if events[event] != count {
+			t.Fatalf("traced %d %s events, expected %d", events[event], event, count)
+		}
+	}
+}
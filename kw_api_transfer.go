@@ -2,13 +2,18 @@ package kwlib
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/cmcoffee/go-snuglib/iotimeout"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,9 +25,11 @@ const (
 
 var ErrNoUploadID = fmt.Errorf("Upload ID not found.")
 var ErrUploadNoResp = fmt.Errorf("Unexpected empty resposne from server.")
+var ErrDownloadChecksum = fmt.Errorf("Downloaded file does not match checksum on server.")
+var ErrUploadMismatch = fmt.Errorf("Chunks received by server do not form the start of the file, upload must be started over.")
 
-// Returns chunk_size, total number of chunks and last chunk size.
-func (K *KWAPI) Chunks(total_size int64) (total_chunks int64) {
+// Returns MaxChunkSize, bounded by the chunk sizes kiteworks accepts.
+func (K *KWAPI) chunkSize() int64 {
 	chunk_size := K.MaxChunkSize
 
 	if chunk_size == 0 || chunk_size > kw_chunk_size_max {
@@ -33,6 +40,13 @@ func (K *KWAPI) Chunks(total_size int64) (total_chunks int64) {
 		chunk_size = kw_chunk_size_min
 	}
 
+	return chunk_size
+}
+
+// Returns chunk_size, total number of chunks and last chunk size.
+func (K *KWAPI) Chunks(total_size int64) (total_chunks int64) {
+	chunk_size := K.chunkSize()
+
 	if total_size <= chunk_size {
 		return 1
 	}
@@ -46,6 +60,7 @@ func (K *KWAPI) Chunks(total_size int64) (total_chunks int64) {
 
 const (
 	wd_started = 1 << iota
+	wd_limited
 )
 
 // Webdownloader for external sources
@@ -53,27 +68,43 @@ type web_downloader struct {
 	flag            BitFlag
 	err             error
 	req             *http.Request
-	client          *http.Client
+	client          *KWAPIClient
 	resp            *http.Response
 	offset          int64
 	trans_limiter   *chan struct{}
 	request_timeout time.Duration
+	metrics         Metrics
 }
 
 func (W *web_downloader) Read(p []byte) (n int, err error) {
+	if W.err != nil {
+		return 0, W.err
+	}
 	if !W.flag.Has(wd_started) {
 		if W.req == nil || W.client == nil {
 			return 0, fmt.Errorf("Webdownloader not initialized.")
 		} else {
 			W.flag.Set(wd_started)
+			if W.trans_limiter != nil && *W.trans_limiter != nil {
+				select {
+				case <-*W.trans_limiter:
+					W.flag.Set(wd_limited)
+				case <-W.req.Context().Done():
+					return 0, W.req.Context().Err()
+				}
+			}
 			W.client.Timeout = 0
-			W.resp, err = W.client.Do(W.req)
+			W.resp, err = W.client.do(W.req)
 			if err != nil {
 				return 0, err
 			}
 			if W.resp.StatusCode < 200 || W.resp.StatusCode >= 300 {
 				return 0, fmt.Errorf("GET %s: %s", W.req.URL, W.resp.Status)
 			}
+			if W.offset > 0 && W.resp.StatusCode != http.StatusPartialContent {
+				W.resp.Body.Close()
+				return 0, fmt.Errorf("GET %s: requested byte %d, server did not honor range request.", W.req.URL, W.offset)
+			}
 			if W.offset > 0 {
 				content_range := strings.Split(strings.TrimPrefix(W.resp.Header.Get("Content-Range"), "bytes"), "-")
 				if len(content_range) > 1 {
@@ -86,13 +117,20 @@ func (W *web_downloader) Read(p []byte) (n int, err error) {
 		}
 	}
 	n, err = W.resp.Body.Read(p)
+	if n > 0 && W.metrics != nil {
+		W.metrics.Add(METRIC_TRANSFER_BYTES, Labels{"direction": "download"}, float64(n))
+	}
 	return
 }
 
 func (W *web_downloader) Close() error {
-	if W.trans_limiter != nil {
+	if W.flag.Has(wd_limited) {
+		W.flag.Unset(wd_limited)
 		*W.trans_limiter <- struct{}{}
 	}
+	if W.resp == nil {
+		return nil
+	}
 	return W.resp.Body.Close()
 }
 
@@ -107,6 +145,26 @@ func (W *web_downloader) Seek(offset int64, whence int) (int64, error) {
 
 // Perform External Download from a remote request.
 func (S *KWSession) Download(req *http.Request) ReadSeekCloser {
+	return S.DownloadContext(req.Context(), req)
+}
+
+// Perform External Download from a remote request, reads are aborted when ctx is done.
+// Requests without a host are resolved against the kiteworks base URL.
+func (S *KWSession) DownloadContext(ctx context.Context, req *http.Request) ReadSeekCloser {
+	req = req.WithContext(ctx)
+	if req.URL.Host == NONE {
+		if base, err := S.baseURL(); err == nil {
+			u := *req.URL
+			u.Scheme = base.Scheme
+			u.Host = base.Host
+			u.Path = base.Path + u.Path
+			if u.RawPath != NONE {
+				u.RawPath = base.EscapedPath() + u.RawPath
+			}
+			req.URL = &u
+			req.Host = base.Host
+		}
+	}
 	req.Header.Set("Content-Type", "application/octet-stream")
 
 	if S.AgentString == NONE {
@@ -115,13 +173,111 @@ func (S *KWSession) Download(req *http.Request) ReadSeekCloser {
 		req.Header.Set("User-Agent", S.AgentString)
 	}
 
-	client := S.NewClient()
-	return &web_downloader{
+	dl := &web_downloader{
 		req:             req,
-		client:          client.Client,
 		request_timeout: S.RequestTimeout,
 		trans_limiter:   &S.trans_limiter,
+		metrics:         S.Metrics,
+	}
+
+	client, err := S.TryNewClient()
+	if err != nil {
+		dl.err = err
+		return dl
+	}
+	dl.client = client
+	return dl
+}
+
+// Downloads kiteworks file to dest, resuming a previous partial download when found.
+func (s KWSession) DownloadFile(file_id int, dest string) (err error) {
+	return s.DownloadFileContext(context.Background(), file_id, dest)
+}
+
+// DownloadFile bound to ctx, partial data is kept for resume when ctx is cancelled.
+// Data is written to a temporary file beside dest, verified against the file fingerprint and then renamed to dest.
+func (s KWSession) DownloadFileContext(ctx context.Context, file_id int, dest string) (err error) {
+	var file KWFile
+
+	err = s.CallContext(ctx, APIRequest{
+		Method: "GET",
+		Path:   SetPath("/rest/files/%d", file_id),
+		Output: &file,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp_file := dest + ".incomplete"
+
+	f, err := os.OpenFile(tmp_file, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	finfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	offset := finfo.Size()
+
+	// Start over if partial file is larger than the file on kiteworks.
+	if offset > file.Size {
+		if err = f.Truncate(0); err != nil {
+			f.Close()
+			return err
+		}
+		offset = 0
+	}
+
+	if offset < file.Size {
+		req, err := s.NewRequestContext(ctx, "GET", SetPath("/rest/files/%d/content", file_id), 0)
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		dl := s.DownloadContext(ctx, req)
+		if offset > 0 {
+			Debug("Resuming download of %s at byte %d.", file.Name, offset)
+			if _, err = dl.Seek(offset, 0); err != nil {
+				f.Close()
+				return err
+			}
+		}
+
+		if _, err = f.Seek(offset, 0); err != nil {
+			f.Close()
+			return err
+		}
+
+		src := TransferMonitor(file.Name, file.Size-offset, RightToLeft, dl)
+		_, err = io.Copy(f, src)
+		src.Close()
+		if err != nil {
+			f.Close()
+			return err
+		}
 	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if file.Fingerprint != NONE {
+		sum, err := MD5Sum(tmp_file)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sum, file.Fingerprint) {
+			os.Remove(tmp_file)
+			return ErrDownloadChecksum
+		}
+	}
+
+	return os.Rename(tmp_file, dest)
 }
 
 // Multipart filestreamer
@@ -171,28 +327,26 @@ func (s *streamReadCloser) Read(p []byte) (n int, err error) {
 		if err != nil {
 			return -1, err
 		}
-		if s.eof {
-			s.Close()
-		}
 		for i := 0; i < len(s.r_buff); i++ {
 			s.r_buff[i] = 0
 		}
 	}
+	if s.eof {
+		s.Close()
+	}
 	n, err = s.w_buff.Read(p)
 	return
 }
 
-// Creates a new upload for a folder.
-func (S *KWSession) NewUpload(folder_id int, filename string, file_size int64) (int, error) {
-	var upload struct {
-		ID int `json:"id"`
-	}
+// Creates a new upload for a folder, params are sent along, ie.. PostJSON{"clientModified": WriteKWTime(t)}.
+func (S *KWSession) NewUpload(folder_id int, filename string, file_size int64, params ...interface{}) (int, error) {
+	var upload KWUpload
 
 	if err := S.Call(APIRequest{
 		APIVer: 5,
 		Method: "POST",
 		Path:   SetPath("/rest/folders/%d/actions/initiateUpload", folder_id),
-		Params: SetParams(PostJSON{"filename": filename, "totalSize": file_size, "totalChunks": S.Chunks(file_size)}, Query{"returnEntity": true}),
+		Params: SetParams(PostJSON{"filename": filename, "totalSize": file_size, "totalChunks": S.Chunks(file_size)}, Query{"returnEntity": true}, params),
 		Output: &upload,
 	}); err != nil {
 		return -1, err
@@ -200,16 +354,14 @@ func (S *KWSession) NewUpload(folder_id int, filename string, file_size int64) (
 	return upload.ID, nil
 }
 
-// Create a new file version for an existing file.
-func (S *KWSession) NewVersion(file_id int, filename string, file_size int64) (int, error) {
-	var upload struct {
-		ID int `json:"id"`
-	}
+// Create a new file version for an existing file, params are sent along as with NewUpload.
+func (S *KWSession) NewVersion(file_id int, filename string, file_size int64, params ...interface{}) (int, error) {
+	var upload KWUpload
 
 	if err := S.Call(APIRequest{
 		Method: "POST",
 		Path:   SetPath("/rest/files/%d/actions/initiateUpload", file_id),
-		Params: SetParams(PostJSON{"filename": filename, "totalSize": file_size, "totalChunks": S.Chunks(file_size)}, Query{"returnEntity": true}),
+		Params: SetParams(PostJSON{"filename": filename, "totalSize": file_size, "totalChunks": S.Chunks(file_size)}, Query{"returnEntity": true}, params),
 		Output: &upload,
 	}); err != nil {
 		return -1, err
@@ -219,43 +371,41 @@ func (S *KWSession) NewVersion(file_id int, filename string, file_size int64) (i
 
 // Uploads file from specific local path, uploads in chunks, allows resume.
 func (s KWSession) Upload(filename string, upload_id int, source_reader ReadSeekCloser) (int, error) {
-	if s.trans_limiter != nil {
-		<-s.trans_limiter
-		defer func() { s.trans_limiter<-struct{}{} }()
-	}
+	return s.UploadContext(context.Background(), filename, upload_id, source_reader)
+}
 
-	type upload_data struct {
-		ID             int    `json:"id"`
-		TotalSize      int64  `json:"totalSize"`
-		TotalChunks    int64  `json:"totalChunks"`
-		UploadedSize   int64  `json:"uploadedSize"`
-		UploadedChunks int64  `json:"uploadedChunks"`
-		Finished       bool   `json:"finished"`
-		URI            string `json:"uri"`
+// Upload bound to ctx, cancelling ctx aborts the chunk currently being sent.
+// When UploadThreads is greater than 1, chunks of a new upload are sent concurrently.
+// Chunks of a concurrent upload which failed are resent by the next Upload of the same KWAPI.
+func (s KWSession) UploadContext(ctx context.Context, filename string, upload_id int, source_reader ReadSeekCloser) (int, error) {
+	if s.plan != nil {
+		return s.planUpload(filename, upload_id, source_reader), nil
 	}
 
-	var upload struct {
-		Data []upload_data `json:"data"`
+	if s.trans_limiter != nil {
+		select {
+		case <-s.trans_limiter:
+			defer func() { s.trans_limiter <- struct{}{} }()
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		}
 	}
 
-	err := s.Call(APIRequest{
-		Method: "GET",
-		Path:   "/rest/uploads",
-		Params: SetParams(Query{"locate_id": upload_id, "limit": 1, "with": "(id,totalSize,totalChunks,uploadedChunks,finished,uploadedSize)"}),
-		Output: &upload,
-	})
+	upload_record, err := s.uploadRecord(ctx, upload_id)
 	if err != nil {
 		return -1, err
 	}
 
-	var upload_record upload_data
-
-	if upload.Data != nil && len(upload.Data) > 0 {
-		upload_record = upload.Data[0]
+	if pending, ok := s.chunkGaps(upload_record.ID); ok {
+		return s.uploadParallel(ctx, filename, upload_record, source_reader, pending)
 	}
 
-	if upload_id != upload_record.ID {
-		return -1, ErrNoUploadID
+	if s.UploadThreads > 1 && upload_record.UploadedChunks == 0 && upload_record.TotalChunks > 2 {
+		pending := make([]int64, upload_record.TotalChunks-1)
+		for i := range pending {
+			pending[i] = int64(i)
+		}
+		return s.uploadParallel(ctx, filename, upload_record, source_reader, pending)
 	}
 
 	total_bytes := upload_record.TotalSize
@@ -266,114 +416,447 @@ func (s KWSession) Upload(filename string, upload_id int, source_reader ReadSeek
 	src := TransferMonitor(filename, total_bytes, LeftToRight, source_reader)
 
 	if ChunkIndex > 0 {
-		if upload_record.UploadedSize > 0 && upload_record.UploadedChunks > 0 {
-			if _, err = src.Seek(ChunkSize*ChunkIndex, 0); err != nil {
-				return -1, err
-			}
+		offset, err := s.resumeOffset(upload_record)
+		if err != nil {
+			return -1, err
+		}
+		if _, err = src.Seek(offset, 0); err != nil {
+			return -1, err
 		}
 	}
 
 	transfered_bytes := upload_record.UploadedSize
 
-	w_buff := new(bytes.Buffer)
-
-	var resp_data struct {
-		ID int `json:"id"`
-	}
+	var (
+		file_id int
+		retries uint
+	)
 
 	for transfered_bytes < total_bytes || total_bytes == 0 {
-		w_buff.Reset()
-
-		req, err := s.NewRequest("POST", fmt.Sprintf("/%s", upload_record.URI), 7)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return -1, err
 		}
 
-		if s.Snoop {
-			Snoop("\n[kiteworks]: %s", s.Username)
-			Snoop("--> METHOD: \"POST\" PATH: \"%v\" (CHUNK %d OF %d)\n", req.URL.Path, ChunkIndex+1, upload_record.TotalChunks)
+		final := ChunkIndex == upload_record.TotalChunks-1
+		if final {
+			ChunkSize = total_bytes - transfered_bytes
 		}
 
-		w := multipart.NewWriter(w_buff)
-
-		req.Header.Set("Content-Type", "multipart/form-data; boundary="+w.Boundary())
-
-		if ChunkIndex == upload_record.TotalChunks-1 {
-			q := req.URL.Query()
-			q.Set("returnEntity", "true")
-			q.Set("mode", "full")
-			if s.Snoop {
-				for k, v := range q {
-					Snoop("\\-> QUERY: %s VALUE: %s", k, v)
+		id, resp, err := s.sendChunk(withAttempt(ctx, int(retries)+1), filename, upload_record, ChunkIndex, ChunkSize, final, iotimeout.NewReader(src, s.RequestTimeout))
+		if err != nil {
+			if ctx.Err() != nil {
+				return -1, ctx.Err()
+			}
+			wait, retry := s.shouldRetry(int(retries), err, resp)
+			if !retry {
+				return -1, err
+			}
+			retries++
+			Debug("(CHUNK ERROR) %s -> %s: chunk %d: %s (%d/%d)", s.Username, filename, ChunkIndex+1, err.Error(), retries, s.Retries+1)
+			s.traceRetry("POST", "/"+upload_record.URI, int(retries), err, wait)
+			if KWAPIError(err, TOKEN_ERR) {
+				if err := s.renewToken(ctx, err); err != nil {
+					return -1, err
 				}
 			}
-			req.URL.RawQuery = q.Encode()
-			ChunkSize = total_bytes - transfered_bytes
+			if err := sleepContext(ctx, wait); err != nil {
+				return -1, err
+			}
+
+			// Resync with what kiteworks has received and rewind source to match.
+			if upload_record, err = s.uploadRecord(ctx, upload_id); err != nil {
+				return -1, err
+			}
+			offset, err := s.resumeOffset(upload_record)
+			if err != nil {
+				return -1, err
+			}
+			ChunkSize = upload_record.TotalSize / upload_record.TotalChunks
+			ChunkIndex = upload_record.UploadedChunks
+			transfered_bytes = upload_record.UploadedSize
+			if _, err = src.Seek(offset, 0); err != nil {
+				return -1, err
+			}
+			continue
 		}
+		retries = 0
 
-		err = w.WriteField("compressionMode", "NORMAL")
-		if err != nil {
-			return -1, err
+		if id > 0 {
+			file_id = id
 		}
 
-		err = w.WriteField("index", fmt.Sprintf("%d", ChunkIndex+1))
-		if err != nil {
-			return -1, err
+		ChunkIndex++
+		transfered_bytes = transfered_bytes + ChunkSize
+		if total_bytes == 0 {
+			break
 		}
+	}
 
-		err = w.WriteField("compressionSize", fmt.Sprintf("%d", ChunkSize))
-		if err != nil {
-			return -1, err
+	if file_id == 0 {
+		return -1, ErrUploadNoResp
+	}
+
+	return file_id, nil
+}
+
+// Returns the offset of the source following the chunks received for upload_record.
+// Unless the chunks received are whole chunks from the start of the file, the upload is cancelled and ErrUploadMismatch returned.
+func (s KWSession) resumeOffset(upload_record KWUpload) (int64, error) {
+	chunk_size := upload_record.TotalSize / upload_record.TotalChunks
+	if upload_record.UploadedChunks >= upload_record.TotalChunks || upload_record.UploadedSize != chunk_size*upload_record.UploadedChunks {
+		Debug("Upload %d holds %d bytes in %d chunks of %d, cancelling.", upload_record.ID, upload_record.UploadedSize, upload_record.UploadedChunks, chunk_size)
+		s.cancelUpload(upload_record.ID)
+		return -1, ErrUploadMismatch
+	}
+	return chunk_size * upload_record.UploadedChunks, nil
+}
+
+// Retrieves the upload record for upload_id.
+func (s KWSession) uploadRecord(ctx context.Context, upload_id int) (upload_record KWUpload, err error) {
+	var upload struct {
+		Data []KWUpload `json:"data"`
+	}
+
+	err = s.CallContext(ctx, APIRequest{
+		Method: "GET",
+		Path:   "/rest/uploads",
+		Params: SetParams(NewQuery().Set("locate_id", upload_id).Set("limit", 1).With("id", "totalSize", "totalChunks", "uploadedChunks", "finished", "uploadedSize")),
+		Output: &upload,
+	})
+	if err != nil {
+		return
+	}
+
+	if upload.Data != nil && len(upload.Data) > 0 {
+		upload_record = upload.Data[0]
+	}
+
+	if upload_id != upload_record.ID {
+		return upload_record, ErrNoUploadID
+	}
+
+	return
+}
+
+// Deletes an upload which will not be completed, failure is only logged as kiteworks expires abandoned uploads itself.
+func (s KWSession) cancelUpload(upload_id int) {
+	err := s.Call(APIRequest{
+		Method: "DELETE",
+		Path:   SetPath("/rest/uploads/%d", upload_id),
+	})
+	if err != nil && !errors.Is(err, ErrEntityNotFound) {
+		Debug("Unable to remove upload %d: %s", upload_id, err.Error())
+	}
+}
+
+// Sends chunk number index (starting at 0) of an upload from source, the final chunk completes the upload.
+// Returns the ID provided in the response, which is the file ID for the final chunk, along with the response itself.
+func (s KWSession) sendChunk(ctx context.Context, filename string, upload_record KWUpload, index, size int64, final bool, source io.Reader) (int, *http.Response, error) {
+	w_buff := new(bytes.Buffer)
+
+	req, err := s.NewRequestContext(ctx, "POST", fmt.Sprintf("/%s", upload_record.URI), 7)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	w := multipart.NewWriter(w_buff)
+
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+w.Boundary())
+
+	if final {
+		q := req.URL.Query()
+		q.Set("returnEntity", "true")
+		q.Set("mode", "full")
+		req.URL.RawQuery = q.Encode()
+	}
+
+	err = w.WriteField("compressionMode", "NORMAL")
+	if err != nil {
+		return -1, nil, err
+	}
+
+	err = w.WriteField("index", fmt.Sprintf("%d", index+1))
+	if err != nil {
+		return -1, nil, err
+	}
+
+	err = w.WriteField("compressionSize", fmt.Sprintf("%d", size))
+	if err != nil {
+		return -1, nil, err
+	}
+
+	err = w.WriteField("originalSize", fmt.Sprintf("%d", size))
+	if err != nil {
+		return -1, nil, err
+	}
+
+	f_writer, err := w.CreateFormFile("content", filename)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	post := &streamReadCloser{
+		size,
+		0,
+		make([]byte, 4096),
+		w_buff,
+		source,
+		false,
+		f_writer,
+		w,
+	}
+
+	if err := s.rateWait(ctx); err != nil {
+		return -1, nil, err
+	}
+
+	req.Body = post
+	client, err := s.TryNewClient()
+	if err != nil {
+		return -1, nil, err
+	}
+	client.Timeout = 0
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return -1, resp, err
+	}
+
+	var resp_data struct {
+		ID int `json:"id"`
+	}
+
+	if err := s.decodeJSON(resp, &resp_data); err != nil {
+		return -1, resp, err
+	}
+
+	s.metricAdd(METRIC_TRANSFER_BYTES, Labels{"direction": "upload"}, float64(size))
+	return resp_data.ID, resp, nil
+}
+
+// Returns the chunks kiteworks has yet to accept for a concurrent upload which failed, false if there was none.
+func (K *KWAPI) chunkGaps(upload_id int) ([]int64, bool) {
+	K.chunk_lock.Lock()
+	defer K.chunk_lock.Unlock()
+	pending, ok := K.chunk_gaps[upload_id]
+	return pending, ok
+}
+
+// Records the chunks kiteworks has yet to accept for upload_id, clearing the record when there are none.
+func (K *KWAPI) setChunkGaps(upload_id int, pending []int64) {
+	K.chunk_lock.Lock()
+	defer K.chunk_lock.Unlock()
+	if len(pending) == 0 {
+		delete(K.chunk_gaps, upload_id)
+		return
+	}
+	if K.chunk_gaps == nil {
+		K.chunk_gaps = make(map[int][]int64)
+	}
+	K.chunk_gaps[upload_id] = pending
+}
+
+// Uploads the pending chunks concurrently, up to UploadThreads at a time, then the final chunk.
+// Sources implementing io.ReaderAt are read in place, otherwise chunks are buffered after seeking the source,
+// with fewer chunks sent at once when needed to keep the buffers within UploadBuffer.
+//
+// Chunks complete out of order, so kiteworks may hold chunks following one which failed.
+// The chunks not accepted are recorded, so a later Upload resends those rather than the chunks following the last received.
+func (s KWSession) uploadParallel(ctx context.Context, filename string, upload_record KWUpload, source ReadSeekCloser, pending []int64) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	total_chunks := upload_record.TotalChunks
+	chunk_size := upload_record.TotalSize / total_chunks
+
+	reader_at, has_reader_at := source.(io.ReaderAt)
+
+	threads := s.UploadThreads
+	if threads < 1 {
+		threads = 1
+	}
+	if !has_reader_at {
+		limit := s.UploadBuffer
+		if limit <= 0 {
+			limit = 64 << 20
+		}
+		if max := int(limit / chunk_size); max < threads {
+			threads = max
 		}
+		if threads < 1 {
+			threads = 1
+		}
+	}
 
-		err = w.WriteField("originalSize", fmt.Sprintf("%d", ChunkSize))
-		if err != nil {
-			return -1, err
+	// Progress of all chunks is reported through a single monitor.
+	monitor := TransferMonitor(filename, upload_record.TotalSize, LeftToRight, &progress_source{})
+	defer monitor.Close()
+
+	var monitor_lock sync.Mutex
+
+	// Chunks accepted by an earlier attempt count as sent.
+	io.CopyN(ioutil.Discard, monitor, chunk_size*(total_chunks-1-int64(len(pending))))
+
+	progress := func(n int64) {
+		monitor_lock.Lock()
+		defer monitor_lock.Unlock()
+		io.CopyN(ioutil.Discard, monitor, n)
+	}
+
+	var src_lock sync.Mutex
+
+	// Provides a reader for chunk index, each call provides a fresh reader for retries.
+	chunk_reader := func(index, size int64) (func() io.Reader, error) {
+		offset := chunk_size * index
+		var sent int64
+		if has_reader_at {
+			return func() io.Reader {
+				return &chunk_progress{io.NewSectionReader(reader_at, offset, size), 0, &sent, progress}
+			}, nil
+		}
+		buf := make([]byte, size)
+		src_lock.Lock()
+		defer src_lock.Unlock()
+		if _, err := source.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(source, buf); err != nil {
+			return nil, err
 		}
+		return func() io.Reader { return &chunk_progress{bytes.NewReader(buf), 0, &sent, progress} }, nil
+	}
 
-		f_writer, err := w.CreateFormFile("content", filename)
+	// Sends chunk, retrying on failure.
+	send := func(index, size int64, final bool) (id int, err error) {
+		reader, err := chunk_reader(index, size)
 		if err != nil {
 			return -1, err
 		}
-
-		if s.Snoop {
-			Snoop(w_buff.String())
+		for i := 0; i <= int(s.Retries); i++ {
+			var resp *http.Response
+			id, resp, err = s.sendChunk(withAttempt(ctx, i+1), filename, upload_record, index, size, final, iotimeout.NewReader(reader(), s.RequestTimeout))
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+			wait, retry := s.shouldRetry(i, err, resp)
+			if !retry {
+				break
+			}
+			Debug("(CHUNK ERROR) %s -> %s: chunk %d: %s (%d/%d)", s.Username, filename, index+1, err.Error(), i+1, s.Retries+1)
+			s.traceRetry("POST", "/"+upload_record.URI, i+1, err, wait)
+			if KWAPIError(err, TOKEN_ERR) {
+				if err := s.renewToken(ctx, err); err != nil {
+					return -1, err
+				}
+			}
+			if err := sleepContext(ctx, wait); err != nil {
+				return -1, err
+			}
 		}
+		return
+	}
 
-		post := &streamReadCloser{
-			ChunkSize,
-			0,
-			make([]byte, 4096),
-			w_buff,
-			iotimeout.NewReader(src, s.RequestTimeout),
-			false,
-			f_writer,
-			w,
-		}
+	var (
+		wg        sync.WaitGroup
+		err_lock  sync.Mutex
+		first_err error
+	)
 
-		req.Body = post
-		client := s.NewClient()
-		client.Timeout = 0
+	accepted := make([]bool, len(pending))
 
-		resp, err := client.Do(req)
-		if err != nil {
-			return -1, err
-		}
+	limiter := make(chan struct{}, threads)
 
-		if err := s.decodeJSON(resp, &resp_data); err != nil {
-			return -1, err
+	// Send all but the final chunk concurrently.
+	for i, index := range pending {
+		select {
+		case limiter <- struct{}{}:
+		case <-ctx.Done():
 		}
-
-		ChunkIndex++
-		transfered_bytes = transfered_bytes + ChunkSize
-		if total_bytes == 0 {
+		if ctx.Err() != nil {
 			break
 		}
+		wg.Add(1)
+		go func(i int, index int64) {
+			defer func() {
+				<-limiter
+				wg.Done()
+			}()
+			if _, err := send(index, chunk_size, false); err != nil {
+				err_lock.Lock()
+				if first_err == nil {
+					first_err = err
+				}
+				err_lock.Unlock()
+				cancel()
+				return
+			}
+			accepted[i] = true
+		}(i, index)
+	}
+
+	wg.Wait()
+
+	var missing []int64
+	for i, index := range pending {
+		if !accepted[i] {
+			missing = append(missing, index)
+		}
+	}
+	s.setChunkGaps(upload_record.ID, missing)
+
+	if first_err != nil {
+		return -1, first_err
+	}
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+
+	// Final chunk completes the upload once all others have been received.
+	file_id, err := send(total_chunks-1, upload_record.TotalSize-chunk_size*(total_chunks-1), true)
+	if err != nil {
+		return -1, err
 	}
 
-	if resp_data.ID == 0 {
+	if file_id <= 0 {
 		return -1, ErrUploadNoResp
 	}
 
-	return resp_data.ID, nil
+	return file_id, nil
+}
+
+// Source for a transfer monitor which only counts the bytes read from it.
+type progress_source struct {
+	offset int64
+}
+
+func (p *progress_source) Read(b []byte) (int, error) {
+	p.offset = p.offset + int64(len(b))
+	return len(b), nil
+}
+
+func (p *progress_source) Seek(offset int64, whence int) (int64, error) {
+	p.offset = offset
+	return offset, nil
+}
+
+func (p *progress_source) Close() error {
+	return nil
+}
+
+// Reports bytes of a chunk as they are read, bytes read again when the chunk is resent are reported once.
+type chunk_progress struct {
+	source   io.Reader
+	read     int64
+	reported *int64
+	report   func(n int64)
+}
+
+func (c *chunk_progress) Read(p []byte) (n int, err error) {
+	n, err = c.source.Read(p)
+	c.read = c.read + int64(n)
+	if c.read > *c.reported {
+		c.report(c.read - *c.reported)
+		*c.reported = c.read
+	}
+	return
 }
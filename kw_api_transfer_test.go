@@ -0,0 +1,393 @@
+package kwlib_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cmcoffee/go-kwlib"
+	"github.com/cmcoffee/go-kwlib/kwlibtest"
+)
+
+// Writes content to a file in a temporary directory, returning its path.
+func tempFile(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Returns content spanning several chunks of 1MB.
+func testContent() []byte {
+	return bytes.Repeat([]byte("0123456789abcdefg"), 200000)
+}
+
+// Checks the content kiteworks holds for file_id.
+func checkContent(t *testing.T, srv *kwlibtest.Server, file_id int, expected []byte) {
+	t.Helper()
+	got, ok := srv.FileContent(file_id)
+	if !ok {
+		t.Fatalf("file %d not found", file_id)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Fatalf("file %d holds %d bytes, expected %d bytes sent", file_id, len(got), len(expected))
+	}
+}
+
+func TestUpload(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	data := testContent()
+	path := tempFile(t, "upload.bin", data)
+
+	upload_id, err := s.NewUpload(user.BaseDirID, "upload.bin", int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	file_id, err := s.Upload("upload.bin", upload_id, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, srv, file_id, data)
+}
+
+// Fails reads once limit bytes have been read.
+type failingReader struct {
+	*os.File
+	limit int64
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.limit <= 0 {
+		return 0, errors.New("read failed")
+	}
+	if int64(len(p)) > f.limit {
+		p = p[:f.limit]
+	}
+	n, err := f.File.Read(p)
+	f.limit = f.limit - int64(n)
+	return n, err
+}
+
+func TestUploadResume(t *testing.T) {
+	srv, s, user := testSession(t)
+	s.Retries = 0
+
+	data := testContent()
+	path := tempFile(t, "resume.bin", data)
+
+	upload_id, err := s.NewUpload(user.BaseDirID, "resume.bin", int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Interrupt the upload partway through the second chunk.
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Upload("resume.bin", upload_id, &failingReader{f, 1<<20 + 1000}); err == nil {
+		t.Fatal("interrupted upload did not fail")
+	}
+	f.Close()
+
+	var uploads struct {
+		Data []kwlib.KWUpload `json:"data"`
+	}
+	if err := s.Call(kwlib.APIRequest{Method: "GET", Path: "/rest/uploads", Output: &uploads}); err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads.Data) != 1 || uploads.Data[0].UploadedChunks != 1 {
+		t.Fatalf("expected one upload with one chunk received, got %+v", uploads.Data)
+	}
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	file_id, err := s.Upload("resume.bin", upload_id, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, srv, file_id, data)
+}
+
+func TestDownload(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	data := testContent()
+	file := srv.AddFile(user.BaseDirID, "download.bin", data)
+
+	req, err := s.NewRequest("GET", kwlib.SetPath("/rest/files/%d/content", file.ID), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := s.Download(req)
+	defer dl.Close()
+
+	got, err := ioutil.ReadAll(dl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes, expected %d", len(got), len(data))
+	}
+
+	// Seeking before reading starts from the offset with a range request.
+	req, err = s.NewRequest("GET", kwlib.SetPath("/rest/files/%d/content", file.ID), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl = s.Download(req)
+	defer dl.Close()
+
+	if _, err := dl.Seek(1000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = ioutil.ReadAll(dl); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[1000:]) {
+		t.Fatalf("downloaded %d bytes from offset 1000, expected %d", len(got), len(data)-1000)
+	}
+}
+
+// Hides io.ReaderAt of the file, so chunks are read by seeking.
+type seekOnly struct {
+	f *os.File
+}
+
+func (s seekOnly) Read(p []byte) (int, error)                   { return s.f.Read(p) }
+func (s seekOnly) Seek(offset int64, whence int) (int64, error) { return s.f.Seek(offset, whence) }
+func (s seekOnly) Close() error                                 { return s.f.Close() }
+
+func TestUploadParallel(t *testing.T) {
+	srv, s, user := testSession(t)
+	s.UploadThreads = 3
+	s.Retries = 1
+
+	data := testContent()
+	path := tempFile(t, "parallel.bin", data)
+
+	sources := map[string]func(f *os.File) kwlib.ReadSeekCloser{
+		"reader_at": func(f *os.File) kwlib.ReadSeekCloser { return f },
+		"seek_only": func(f *os.File) kwlib.ReadSeekCloser { return seekOnly{f} },
+	}
+
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+			// A failed chunk is resent.
+			srv.Fail("POST", "/rest/uploads/", 500, "ERR_INTERNAL_SERVER_ERROR", 1)
+
+			upload_id, err := s.NewUpload(user.BaseDirID, name, int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			file_id, err := s.Upload(name, upload_id, source(f))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkContent(t, srv, file_id, data)
+		})
+	}
+
+	// Buffers smaller than a chunk still send one chunk at a time.
+	s.UploadBuffer = 1000
+	t.Run("seek_only_buffer", func(t *testing.T) {
+		upload_id, err := s.NewUpload(user.BaseDirID, "buffered", int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		file_id, err := s.Upload("buffered", upload_id, seekOnly{f})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkContent(t, srv, file_id, data)
+	})
+}
+
+func TestUploadParallelResume(t *testing.T) {
+	srv, s, user := testSession(t)
+	s.UploadThreads = 3
+	s.Retries = 0
+
+	// Fails the third chunk once, after the chunks following it have been received.
+	var failed int32
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/rest/uploads/") && r.Method == "POST" {
+			body, _ := ioutil.ReadAll(r.Body)
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if bytes.Contains(body, []byte("name=\"index\"\r\n\r\n3\r\n")) && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+				time.Sleep(250 * time.Millisecond)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				w.Write([]byte(`{"errors":[{"code":"ERR_INPUT_INVALID","message":"Bad chunk"}]}`))
+				return
+			}
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer plain.Close()
+	s.BaseURL = plain.URL
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 8<<16)
+	path := tempFile(t, "resume.bin", data)
+
+	upload_id, err := s.NewUpload(user.BaseDirID, "resume.bin", int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := s.Upload("resume.bin", upload_id, f); err == nil {
+		t.Fatal("expected the failed chunk to fail the upload")
+	}
+
+	// The resumed upload fills in the chunk which failed, not the chunks following the count received.
+	file_id, err := s.Upload("resume.bin", upload_id, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, srv, file_id, data)
+}
+
+func TestUploadMismatch(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	data := testContent()
+	path := tempFile(t, "mismatch.bin", data)
+
+	upload_id, err := s.NewUpload(user.BaseDirID, "mismatch.bin", int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reports a chunk received, with a size which is not that of a whole chunk.
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rest/uploads" && r.Method == "GET" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"data":[{"id":%d,"totalSize":%d,"totalChunks":4,"uploadedChunks":1,"uploadedSize":1000}]}`, upload_id, len(data))
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer plain.Close()
+	base_url := s.BaseURL
+	s.BaseURL = plain.URL
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := s.Upload("mismatch.bin", upload_id, f); !errors.Is(err, kwlib.ErrUploadMismatch) {
+		t.Fatalf("expected ErrUploadMismatch, got %v", err)
+	}
+
+	// The upload is cancelled, rather than appended to from the wrong offset.
+	s.BaseURL = base_url
+	if n := pendingUploads(t, s); n != 0 {
+		t.Fatalf("%d uploads left open", n)
+	}
+}
+
+func TestUploadChunkRetry(t *testing.T) {
+	srv, s, user := testSession(t)
+	s.Retries = 2
+
+	data := testContent()
+	path := tempFile(t, "retry.bin", data)
+
+	upload_id, err := s.NewUpload(user.BaseDirID, "retry.bin", int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// A failed chunk and an expired token are both recovered from within the upload.
+	srv.Fail("POST", "/rest/uploads/", 500, "ERR_INTERNAL_SERVER_ERROR", 1)
+	srv.ExpireTokens()
+
+	file_id, err := s.Upload("retry.bin", upload_id, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, srv, file_id, data)
+}
+
+func TestDownloadFile(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	data := testContent()
+	file := srv.AddFile(user.BaseDirID, "download.bin", data)
+	dest := filepath.Join(t.TempDir(), "download.bin")
+
+	// Resumes from a partial download left by an earlier attempt.
+	if err := ioutil.WriteFile(dest+".incomplete", data[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DownloadFile(file.ID, dest); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes, expected %d", len(got), len(data))
+	}
+	if _, err := os.Stat(dest + ".incomplete"); !os.IsNotExist(err) {
+		t.Fatalf("partial download remains: %v", err)
+	}
+
+	// A partial download holding the wrong content fails verification and is discarded.
+	if err := ioutil.WriteFile(dest+".incomplete", []byte("wrong content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DownloadFile(file.ID, dest); err != kwlib.ErrDownloadChecksum {
+		t.Fatalf("expected ErrDownloadChecksum, got %v", err)
+	}
+	if err := s.DownloadFile(file.ID, dest); err != nil {
+		t.Fatalf("download after checksum failure: %s", err)
+	}
+}
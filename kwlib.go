@@ -92,6 +92,7 @@ func Err(input ...interface{}) {
 }
 
 // Wrapper around go-kvlite.
+// Methods prefixed with Try return errors, the remaining methods are conveniences which exit on error.
 type Database struct {
 	db kvlite.Store
 }
@@ -100,26 +101,62 @@ type Table struct {
 	table kvlite.Table
 }
 
+// Save value to table.
+func (t Table) TrySet(key string, value interface{}) error {
+	return t.table.Set(key, value)
+}
+
 func (t Table) Set(key string, value interface{}) {
-	Critical(t.table.Set(key, value))
+	Critical(t.TrySet(key, value))
+}
+
+// Encrypt value to table.
+func (t Table) TryCryptSet(key string, value interface{}) error {
+	return t.table.CryptSet(key, value)
 }
 
 func (t Table) CryptSet(key string, value interface{}) {
-	Critical(t.table.CryptSet(key, value))
+	Critical(t.TryCryptSet(key, value))
+}
+
+// Retrieve value from table.
+func (t Table) TryGet(key string, output interface{}) (bool, error) {
+	return t.table.Get(key, output)
+}
+
+func (t Table) Get(key string, output interface{}) bool {
+	found, err := t.TryGet(key, output)
+	Critical(err)
+	return found
+}
+
+// Delete value from table.
+func (t Table) TryUnset(key string) error {
+	return t.table.Unset(key)
 }
 
 func (t Table) Unset(key string) {
-	Critical(t.table.Unset(key))
+	Critical(t.TryUnset(key))
+}
+
+// List keys in table.
+func (t Table) TryKeys() ([]string, error) {
+	return t.table.Keys()
 }
 
 func (t Table) Keys() []string {
-	keys, err := t.table.Keys()
+	keys, err := t.TryKeys()
 	Critical(err)
 	return keys
 }
 
+// Count keys in table.
+func (t Table) TryCountKeys() (int, error) {
+	return t.table.CountKeys()
+}
+
 func (t Table) CountKeys() int {
-	count, err := t.table.CountKeys()
+	count, err := t.TryCountKeys()
 	Critical(err)
 	return count
 }
@@ -136,20 +173,27 @@ func OpenDatabase(file string, padlock ...byte) (*Database, error) {
 // Opens go-kvlite database using mac address for lock.
 func SecureDatabase(file string) (*Database, error) {
 	// Provides us the mac address of the first interface.
-	get_mac_addr := func() []byte {
+	get_mac_addr := func() ([]byte, error) {
 		ifaces, err := net.Interfaces()
-		Critical(err)
+		if err != nil {
+			return nil, err
+		}
 
 		for _, v := range ifaces {
 			if len(v.HardwareAddr) == 0 {
 				continue
 			}
-			return v.HardwareAddr
+			return v.HardwareAddr, nil
 		}
-		return nil
+		return nil, nil
+	}
+
+	padlock, err := get_mac_addr()
+	if err != nil {
+		return nil, err
 	}
 
-	db, err := kvlite.Open(file, get_mac_addr()[0:]...)
+	db, err := kvlite.Open(file, padlock[0:]...)
 	if err != nil {
 		if err == kvlite.ErrBadPadlock {
 			Notice("Hardware changes detected, you will need to reauthenticate.")
@@ -159,7 +203,7 @@ func SecureDatabase(file string) (*Database, error) {
 		} else {
 			return nil, err
 		}
-		db, err = kvlite.Open(file, get_mac_addr()[0:]...)
+		db, err = kvlite.Open(file, padlock[0:]...)
 		if err != nil {
 			return nil, err
 		}
@@ -173,24 +217,44 @@ func OpenCache() *Database {
 	return &Database{db}
 }
 
+// Drop table from go-kvlite.
+func (d Database) TryDrop(table string) error {
+	return d.db.Drop(table)
+}
+
 // DB Wrappers to perform fatal error checks on each call.
 func (d Database) Drop(table string) {
-	Critical(d.db.Drop(table))
+	Critical(d.TryDrop(table))
+}
+
+// Encrypt value to go-kvlite.
+func (d Database) TryCryptSet(table, key string, value interface{}) error {
+	return d.db.CryptSet(table, key, value)
 }
 
 // Encrypt value to go-kvlie, fatal on error.
 func (d Database) CryptSet(table, key string, value interface{}) {
-	Critical(d.db.CryptSet(table, key, value))
+	Critical(d.TryCryptSet(table, key, value))
 }
 
 // Save value to go-kvlite.
+func (d Database) TrySet(table, key string, value interface{}) error {
+	return d.db.Set(table, key, value)
+}
+
+// Save value to go-kvlite, fatal on error.
 func (d Database) Set(table, key string, value interface{}) {
-	Critical(d.db.Set(table, key, value))
+	Critical(d.TrySet(table, key, value))
 }
 
 // Retrieve value from go-kvlite.
+func (d Database) TryGet(table, key string, output interface{}) (bool, error) {
+	return d.db.Get(table, key, output)
+}
+
+// Retrieve value from go-kvlite, fatal on error.
 func (d Database) Get(table, key string, output interface{}) bool {
-	found, err := d.db.Get(table, key, output)
+	found, err := d.TryGet(table, key, output)
 	Critical(err)
 	return found
 }
@@ -200,34 +264,59 @@ func (d Database) Table(table string) Table {
 }
 
 // List keys in go-kvlite.
+func (d Database) TryKeys(table string) ([]string, error) {
+	return d.db.Keys(table)
+}
+
+// List keys in go-kvlite, fatal on error.
 func (d Database) Keys(table string) []string {
-	keylist, err := d.db.Keys(table)
+	keylist, err := d.TryKeys(table)
 	Critical(err)
 	return keylist
 }
 
 // Count keys in table.
+func (d Database) TryCountKeys(table string) (int, error) {
+	return d.db.CountKeys(table)
+}
+
+// Count keys in table, fatal on error.
 func (d Database) CountKeys(table string) int {
-	count, err := d.db.CountKeys(table)
+	count, err := d.TryCountKeys(table)
 	Critical(err)
 	return count
 }
 
 // List Tables in DB
+func (d Database) TryTables() ([]string, error) {
+	return d.db.Tables()
+}
+
+// List Tables in DB, fatal on error.
 func (d Database) Tables() []string {
-	tables, err := d.db.Tables()
+	tables, err := d.TryTables()
 	Critical(err)
 	return tables
 }
 
 // Delete value from go-kvlite.
+func (d Database) TryUnset(table, key string) error {
+	return d.db.Unset(table, key)
+}
+
+// Delete value from go-kvlite, fatal on error.
 func (d Database) Unset(table, key string) {
-	Critical(d.db.Unset(table, key))
+	Critical(d.TryUnset(table, key))
 }
 
 // Closes go-kvlite database.
+func (d Database) TryClose() error {
+	return d.db.Close()
+}
+
+// Closes go-kvlite database, fatal on error.
 func (d Database) Close() {
-	Critical(d.db.Close())
+	Critical(d.TryClose())
 }
 
 // Fatal Error Check
@@ -255,6 +344,11 @@ type FileInfo struct {
 	string
 }
 
+// Returns the full path of the file.
+func (f FileInfo) Path() string {
+	return f.string
+}
+
 // Scans parent_folder for all subfolders and files.
 func ScanPath(parent_folder string) (folders []string, files []FileInfo) {
 	folders = []string{filepath.Clean(parent_folder)}
@@ -0,0 +1,112 @@
+package kwlibtest
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/cmcoffee/go-kwlib"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Handles /oauth/token for password, refresh_token and authorization_code grants.
+func (S *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		write_error(w, http.StatusMethodNotAllowed, "ERR_REQUEST_METHOD_NOT_ALLOWED", "Method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		write_oauth_error(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if r.PostForm.Get("client_id") != S.ClientID || r.PostForm.Get("client_secret") != S.ClientSecret {
+		write_oauth_error(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	S.auth_lock.Lock()
+	defer S.auth_lock.Unlock()
+
+	var email string
+
+	switch r.PostForm.Get("grant_type") {
+	case "password":
+		user, ok := S.users[strings.ToLower(r.PostForm.Get("username"))]
+		if !ok || user.password != r.PostForm.Get("password") {
+			write_oauth_error(w, http.StatusBadRequest, "invalid_grant", "Invalid username or password")
+			return
+		}
+		email = user.Email
+	case "refresh_token":
+		var ok bool
+		refresh_token := r.PostForm.Get("refresh_token")
+		if email, ok = S.refresh[refresh_token]; !ok {
+			write_oauth_error(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+			return
+		}
+		delete(S.refresh, refresh_token)
+	case "authorization_code":
+		var err error
+		if email, err = S.verifySignature(r.PostForm.Get("code")); err != nil {
+			write_oauth_error(w, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		}
+	default:
+		write_oauth_error(w, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant type")
+		return
+	}
+
+	access_token := string(kwlib.RandBytes(32))
+	refresh_token := string(kwlib.RandBytes(32))
+
+	S.tokens[access_token] = &fake_token{email, time.Now().Add(S.TokenTTL)}
+	S.refresh[refresh_token] = email
+
+	write_json(w, http.StatusOK, &kwlib.KWAuth{
+		AccessToken:  access_token,
+		Scope:        "*/*/*",
+		RefreshToken: refresh_token,
+		Expires:      int64(S.TokenTTL / time.Second),
+	})
+}
+
+// Verifies a signature authorization code, returns the email it was issued for, requires auth_lock.
+func (S *Server) verifySignature(code string) (email string, err error) {
+	if S.signature_key == kwlib.NONE {
+		return kwlib.NONE, fmt.Errorf("Signature authentication is not enabled")
+	}
+
+	parts := strings.Split(code, "|@@|")
+	if len(parts) != 5 {
+		return kwlib.NONE, fmt.Errorf("Malformed authorization code")
+	}
+
+	client_id, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return kwlib.NONE, err
+	}
+	username, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return kwlib.NONE, err
+	}
+
+	if string(client_id) != S.ClientID {
+		return kwlib.NONE, fmt.Errorf("Authorization code issued for another client")
+	}
+
+	mac := hmac.New(sha1.New, []byte(S.signature_key))
+	mac.Write([]byte(fmt.Sprintf("%s|@@|%s|@@|%s|@@|%s", client_id, username, parts[2], parts[3])))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(parts[4])) {
+		return kwlib.NONE, fmt.Errorf("Invalid signature")
+	}
+
+	user, ok := S.users[strings.ToLower(string(username))]
+	if !ok {
+		return kwlib.NONE, fmt.Errorf("Unknown user %s", username)
+	}
+	return user.Email, nil
+}
@@ -0,0 +1,597 @@
+package kwlibtest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/cmcoffee/go-kwlib"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type fake_file struct {
+	kwlib.KWFile
+	content  []byte
+	versions []kwlib.KWFile
+}
+
+type fake_upload struct {
+	kwlib.KWUpload
+	folder_id int
+	file_id   int
+	filename  string
+	modified  string // Client modified time, when provided.
+	chunks    map[int][]byte
+}
+
+// Creates a folder, requires tree_lock.
+func (S *Server) newFolder(parent_id int, name string, user_id int) *kwlib.KWFolder {
+	now := kwlib.WriteKWTime(time.Now())
+	folder := &kwlib.KWFolder{
+		ID:       S.newID(),
+		Name:     name,
+		ParentID: parent_id,
+		UserID:   user_id,
+		Type:     "d",
+		Created:  now,
+		Modified: now,
+	}
+	folder.Path = S.folderPath(folder)
+	S.folders[folder.ID] = folder
+	return folder
+}
+
+// Creates a file, requires tree_lock.
+func (S *Server) newFile(folder_id int, name string, user_id int, content []byte) *fake_file {
+	now := kwlib.WriteKWTime(time.Now())
+	f := &fake_file{
+		KWFile: kwlib.KWFile{
+			ID:             S.newID(),
+			Name:           name,
+			ParentID:       folder_id,
+			UserID:         user_id,
+			Type:           "f",
+			Created:        now,
+			Modified:       now,
+			ClientCreated:  now,
+			ClientModified: now,
+		},
+	}
+	f.setContent(content)
+	S.files[f.ID] = f
+	return f
+}
+
+// Replaces file content, updating size and fingerprint.
+func (f *fake_file) setContent(content []byte) {
+	sum := md5.Sum(content)
+	f.content = content
+	f.Size = int64(len(content))
+	f.Fingerprint = hex.EncodeToString(sum[:])
+}
+
+// Builds the full path of a folder, requires tree_lock.
+func (S *Server) folderPath(folder *kwlib.KWFolder) string {
+	if parent, ok := S.folders[folder.ParentID]; ok {
+		return parent.Path + "/" + folder.Name
+	}
+	return folder.Name
+}
+
+// Returns true if an entity named name already exists in folder, requires tree_lock.
+func (S *Server) nameExists(folder_id int, name string) bool {
+	for _, f := range S.folders {
+		if f.ParentID == folder_id && strings.EqualFold(f.Name, name) {
+			return true
+		}
+	}
+	for _, f := range S.files {
+		if f.ParentID == folder_id && strings.EqualFold(f.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns subfolders of folder_id ordered by ID, requires tree_lock.
+func (S *Server) subFolders(folder_id int) (output []*kwlib.KWFolder) {
+	var ids []int
+	for id, f := range S.folders {
+		if f.ParentID == folder_id {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range sorted_ids(ids) {
+		output = append(output, S.folders[id])
+	}
+	return
+}
+
+// Returns files in folder_id ordered by ID, requires tree_lock.
+func (S *Server) subFiles(folder_id int) (output []*fake_file) {
+	var ids []int
+	for id, f := range S.files {
+		if f.ParentID == folder_id {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range sorted_ids(ids) {
+		output = append(output, S.files[id])
+	}
+	return
+}
+
+// Removes folder and everything beneath it, requires tree_lock.
+func (S *Server) removeFolder(folder_id int) {
+	for _, f := range S.subFolders(folder_id) {
+		S.removeFolder(f.ID)
+	}
+	for _, f := range S.subFiles(folder_id) {
+		delete(S.files, f.ID)
+	}
+	delete(S.folders, folder_id)
+}
+
+// Copies folder and everything beneath it to dest_id, requires tree_lock.
+func (S *Server) copyFolder(folder_id, dest_id int) *kwlib.KWFolder {
+	src := S.folders[folder_id]
+	folder := S.newFolder(dest_id, src.Name, src.UserID)
+	for _, f := range S.subFolders(folder_id) {
+		if f.ID != folder.ID {
+			S.copyFolder(f.ID, folder.ID)
+		}
+	}
+	for _, f := range S.subFiles(folder_id) {
+		S.newFile(folder.ID, f.Name, f.UserID, append([]byte(nil), f.content...))
+	}
+	return folder
+}
+
+// Updates paths of folder and its subfolders after a move, requires tree_lock.
+func (S *Server) repath(folder *kwlib.KWFolder) {
+	folder.Path = S.folderPath(folder)
+	for _, f := range S.subFolders(folder.ID) {
+		S.repath(f)
+	}
+}
+
+// Decodes JSON request body into v.
+func read_json(r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
+
+// Writes the entity if returnEntity was requested.
+func write_entity(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	if r.URL.Query().Get("returnEntity") == "true" {
+		write_json(w, status, v)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// Routes /rest/ requests.
+func (S *Server) handleREST(w http.ResponseWriter, r *http.Request, user *fake_user, path []string) {
+	var id int
+
+	if len(path) > 1 {
+		id, _ = strconv.Atoi(path[1])
+	}
+
+	switch path[0] {
+	case "users":
+		if len(path) == 2 && path[1] == "me" && r.Method == http.MethodGet {
+			write_json(w, http.StatusOK, user.KWUser)
+			return
+		}
+	case "folders", "files":
+		if r.Method == http.MethodGet {
+			S.tree_lock.RLock()
+			defer S.tree_lock.RUnlock()
+		} else {
+			S.tree_lock.Lock()
+			defer S.tree_lock.Unlock()
+		}
+	}
+
+	switch path[0] {
+	case "folders":
+		if len(path) == 2 && path[1] == "top" && r.Method == http.MethodGet {
+			var items []interface{}
+			for _, f := range S.subFolders(0) {
+				items = append(items, f)
+			}
+			write_json(w, http.StatusOK, paginate(r, items))
+			return
+		}
+		if len(path) == 1 && r.Method == http.MethodDelete {
+			S.bulkDelete(w, r, func(id int) bool {
+				_, ok := S.folders[id]
+				return ok
+			}, S.removeFolder)
+			return
+		}
+		if len(path) > 1 {
+			S.handleFolder(w, r, user, id, path[2:])
+			return
+		}
+	case "files":
+		if len(path) == 1 && r.Method == http.MethodDelete {
+			S.bulkDelete(w, r, func(id int) bool {
+				_, ok := S.files[id]
+				return ok
+			}, func(id int) { delete(S.files, id) })
+			return
+		}
+		if len(path) > 1 {
+			S.handleFile(w, r, user, id, path[2:])
+			return
+		}
+	case "uploads":
+		if len(path) == 1 && r.Method == http.MethodGet {
+			S.listUploads(w, r)
+			return
+		}
+		if len(path) == 2 && r.Method == http.MethodPost {
+			S.uploadChunk(w, r, user, id)
+			return
+		}
+		if len(path) == 2 && r.Method == http.MethodDelete {
+			S.upload_lock.Lock()
+			_, ok := S.uploads[id]
+			delete(S.uploads, id)
+			S.upload_lock.Unlock()
+			if !ok {
+				write_error(w, http.StatusNotFound, "ERR_ENTITY_NOT_FOUND", "Upload not found")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	write_error(w, http.StatusNotFound, "ERR_REQUEST_NOT_FOUND", fmt.Sprintf("No route for %s %s", r.Method, r.URL.Path))
+}
+
+// Deletes every entity listed in the id:in query, nothing is deleted unless all exist.
+func (S *Server) bulkDelete(w http.ResponseWriter, r *http.Request, exists func(id int) bool, remove func(id int)) {
+	var ids []int
+	for _, v := range strings.Split(r.URL.Query().Get("id:in"), ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			write_error(w, http.StatusBadRequest, "ERR_INPUT_INVALID", "id:in must be a list of IDs")
+			return
+		}
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		if !exists(id) {
+			write_error(w, http.StatusNotFound, "ERR_ENTITY_NOT_FOUND", fmt.Sprintf("Entity %d not found", id))
+			return
+		}
+	}
+	for _, id := range ids {
+		remove(id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handles /rest/folders/{id}/...
+func (S *Server) handleFolder(w http.ResponseWriter, r *http.Request, user *fake_user, folder_id int, path []string) {
+	folder, ok := S.folders[folder_id]
+	if !ok {
+		write_error(w, http.StatusNotFound, "ERR_ENTITY_NOT_FOUND", "Folder not found")
+		return
+	}
+
+	route := r.Method + " " + strings.Join(path, "/")
+
+	switch route {
+	case "GET ":
+		write_json(w, http.StatusOK, folder)
+	case "DELETE ":
+		S.removeFolder(folder_id)
+		w.WriteHeader(http.StatusNoContent)
+	case "GET folders":
+		var items []interface{}
+		for _, f := range S.subFolders(folder_id) {
+			items = append(items, f)
+		}
+		write_json(w, http.StatusOK, paginate(r, items))
+	case "GET files":
+		var items []interface{}
+		for _, f := range S.subFiles(folder_id) {
+			items = append(items, f.KWFile)
+		}
+		write_json(w, http.StatusOK, paginate(r, items))
+	case "GET children":
+		var items []interface{}
+		for _, f := range S.subFolders(folder_id) {
+			items = append(items, f)
+		}
+		for _, f := range S.subFiles(folder_id) {
+			items = append(items, f.KWFile)
+		}
+		write_json(w, http.StatusOK, paginate(r, items))
+	case "GET members":
+		items := []interface{}{
+			kwlib.KWMember{
+				ObjectID: folder_id,
+				RoleID:   5,
+				UserID:   user.ID,
+				User:     user.KWUser,
+				Role:     kwlib.KWRole{ID: 5, Name: "Owner", Rank: 5},
+			},
+		}
+		write_json(w, http.StatusOK, paginate(r, items))
+	case "POST folders":
+		var input struct {
+			Name string `json:"name"`
+		}
+		if err := read_json(r, &input); err != nil || input.Name == kwlib.NONE {
+			write_error(w, http.StatusUnprocessableEntity, "ERR_INPUT_REQUIRED", "Folder name is required")
+			return
+		}
+		if S.nameExists(folder_id, input.Name) {
+			write_error(w, http.StatusConflict, "ERR_ENTITY_EXISTS", "Entity exists")
+			return
+		}
+		write_entity(w, r, http.StatusCreated, S.newFolder(folder_id, input.Name, user.ID))
+	case "POST actions/move", "POST actions/copy":
+		var input struct {
+			Dest int `json:"destinationFolderId"`
+		}
+		read_json(r, &input)
+		if _, ok := S.folders[input.Dest]; !ok {
+			write_error(w, http.StatusNotFound, "ERR_ENTITY_NOT_FOUND", "Destination folder not found")
+			return
+		}
+		if S.nameExists(input.Dest, folder.Name) {
+			write_error(w, http.StatusConflict, "ERR_ENTITY_EXISTS", "Entity exists")
+			return
+		}
+		if path[1] == "copy" {
+			write_entity(w, r, http.StatusCreated, S.copyFolder(folder_id, input.Dest))
+			return
+		}
+		folder.ParentID = input.Dest
+		S.repath(folder)
+		write_entity(w, r, http.StatusOK, folder)
+	case "POST actions/initiateUpload":
+		S.initiateUpload(w, r, user, folder_id, 0)
+	default:
+		write_error(w, http.StatusNotFound, "ERR_REQUEST_NOT_FOUND", fmt.Sprintf("No route for %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// Handles /rest/files/{id}/...
+func (S *Server) handleFile(w http.ResponseWriter, r *http.Request, user *fake_user, file_id int, path []string) {
+	file, ok := S.files[file_id]
+	if !ok {
+		write_error(w, http.StatusNotFound, "ERR_ENTITY_NOT_FOUND", "File not found")
+		return
+	}
+
+	route := r.Method + " " + strings.Join(path, "/")
+
+	switch route {
+	case "GET ":
+		write_json(w, http.StatusOK, file.KWFile)
+	case "DELETE ":
+		delete(S.files, file_id)
+		w.WriteHeader(http.StatusNoContent)
+	case "GET versions":
+		items := []interface{}{file.KWFile}
+		for i := len(file.versions) - 1; i >= 0; i-- {
+			items = append(items, file.versions[i])
+		}
+		write_json(w, http.StatusOK, paginate(r, items))
+	case "GET content":
+		modified, _ := kwlib.ReadKWTime(file.Modified)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, file.Name, modified, bytes.NewReader(file.content))
+	case "POST actions/move", "POST actions/copy":
+		var input struct {
+			Dest int `json:"destinationFolderId"`
+		}
+		read_json(r, &input)
+		if _, ok := S.folders[input.Dest]; !ok {
+			write_error(w, http.StatusNotFound, "ERR_ENTITY_NOT_FOUND", "Destination folder not found")
+			return
+		}
+		if S.nameExists(input.Dest, file.Name) {
+			write_error(w, http.StatusConflict, "ERR_ENTITY_EXISTS", "Entity exists")
+			return
+		}
+		if path[1] == "copy" {
+			write_entity(w, r, http.StatusCreated, S.newFile(input.Dest, file.Name, user.ID, append([]byte(nil), file.content...)).KWFile)
+			return
+		}
+		file.ParentID = input.Dest
+		write_entity(w, r, http.StatusOK, file.KWFile)
+	case "POST actions/initiateUpload":
+		S.initiateUpload(w, r, user, file.ParentID, file_id)
+	default:
+		write_error(w, http.StatusNotFound, "ERR_REQUEST_NOT_FOUND", fmt.Sprintf("No route for %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// Starts a new upload to folder_id, or a new version of file_id when non-zero, requires tree_lock.
+func (S *Server) initiateUpload(w http.ResponseWriter, r *http.Request, user *fake_user, folder_id, file_id int) {
+	var input struct {
+		Filename    string `json:"filename"`
+		TotalSize   int64  `json:"totalSize"`
+		TotalChunks int64  `json:"totalChunks"`
+		Modified    string `json:"clientModified"`
+	}
+	if err := read_json(r, &input); err != nil || input.Filename == kwlib.NONE {
+		write_error(w, http.StatusUnprocessableEntity, "ERR_INPUT_REQUIRED", "Filename is required")
+		return
+	}
+	if file_id == 0 && S.nameExists(folder_id, input.Filename) {
+		write_error(w, http.StatusConflict, "ERR_ENTITY_EXISTS", "Entity exists")
+		return
+	}
+
+	upload := &fake_upload{
+		folder_id: folder_id,
+		file_id:   file_id,
+		filename:  input.Filename,
+		modified:  input.Modified,
+		chunks:    make(map[int][]byte),
+	}
+	upload.ID = S.newID()
+	upload.TotalSize = input.TotalSize
+	upload.TotalChunks = input.TotalChunks
+	upload.URI = fmt.Sprintf("rest/uploads/%d", upload.ID)
+
+	S.upload_lock.Lock()
+	S.uploads[upload.ID] = upload
+	S.upload_lock.Unlock()
+
+	write_entity(w, r, http.StatusCreated, upload.KWUpload)
+}
+
+// Handles GET /rest/uploads, supports locate_id.
+func (S *Server) listUploads(w http.ResponseWriter, r *http.Request) {
+	S.upload_lock.Lock()
+	defer S.upload_lock.Unlock()
+
+	var ids []int
+	for id := range S.uploads {
+		ids = append(ids, id)
+	}
+
+	locate_id, _ := strconv.Atoi(r.URL.Query().Get("locate_id"))
+
+	var items []interface{}
+	for _, id := range sorted_ids(ids) {
+		if locate_id > 0 && id != locate_id {
+			continue
+		}
+		items = append(items, S.uploads[id].KWUpload)
+	}
+	write_json(w, http.StatusOK, paginate(r, items))
+}
+
+// Handles a multipart chunk POST to an upload URI.
+func (S *Server) uploadChunk(w http.ResponseWriter, r *http.Request, user *fake_user, upload_id int) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		write_error(w, http.StatusBadRequest, "ERR_INPUT_INVALID", err.Error())
+		return
+	}
+
+	index, err := strconv.Atoi(r.FormValue("index"))
+	if err != nil || index < 1 {
+		write_error(w, http.StatusUnprocessableEntity, "ERR_INPUT_INVALID", "Invalid chunk index")
+		return
+	}
+
+	f, _, err := r.FormFile("content")
+	if err != nil {
+		write_error(w, http.StatusUnprocessableEntity, "ERR_INPUT_REQUIRED", "Chunk content is required")
+		return
+	}
+	content, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		write_error(w, http.StatusInternalServerError, "ERR_INTERNAL_SERVER_ERROR", err.Error())
+		return
+	}
+
+	if size, err := strconv.ParseInt(r.FormValue("originalSize"), 10, 64); err != nil || size != int64(len(content)) {
+		write_error(w, http.StatusUnprocessableEntity, "ERR_INPUT_INVALID", fmt.Sprintf("Chunk %d declared %s bytes, received %d", index, r.FormValue("originalSize"), len(content)))
+		return
+	}
+
+	S.upload_lock.Lock()
+
+	upload, ok := S.uploads[upload_id]
+	if !ok {
+		S.upload_lock.Unlock()
+		write_error(w, http.StatusNotFound, "ERR_ENTITY_NOT_FOUND", "Upload not found")
+		return
+	}
+	if upload.TotalChunks > 0 && int64(index) > upload.TotalChunks {
+		S.upload_lock.Unlock()
+		write_error(w, http.StatusUnprocessableEntity, "ERR_INPUT_INVALID", "Invalid chunk index")
+		return
+	}
+
+	if old, ok := upload.chunks[index]; ok {
+		upload.UploadedSize = upload.UploadedSize - int64(len(old))
+		upload.UploadedChunks--
+	}
+	upload.chunks[index] = content
+	upload.UploadedSize = upload.UploadedSize + int64(len(content))
+	upload.UploadedChunks++
+
+	if r.URL.Query().Get("mode") != "full" {
+		S.upload_lock.Unlock()
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Final chunk, assemble the file.
+	var buffer bytes.Buffer
+	for i := 1; i <= len(upload.chunks); i++ {
+		chunk, ok := upload.chunks[i]
+		if !ok {
+			S.upload_lock.Unlock()
+			write_error(w, http.StatusUnprocessableEntity, "ERR_INPUT_INVALID", fmt.Sprintf("Chunk %d is missing", i))
+			return
+		}
+		buffer.Write(chunk)
+	}
+
+	if upload.TotalChunks > 0 && int64(len(upload.chunks)) != upload.TotalChunks {
+		S.upload_lock.Unlock()
+		write_error(w, http.StatusUnprocessableEntity, "ERR_INPUT_INVALID", fmt.Sprintf("Received %d of %d chunks", len(upload.chunks), upload.TotalChunks))
+		return
+	}
+
+	if upload.TotalSize > 0 && int64(buffer.Len()) != upload.TotalSize {
+		S.upload_lock.Unlock()
+		write_error(w, http.StatusUnprocessableEntity, "ERR_INPUT_INVALID", fmt.Sprintf("Received %d of %d bytes", buffer.Len(), upload.TotalSize))
+		return
+	}
+
+	delete(S.uploads, upload_id)
+	S.upload_lock.Unlock()
+
+	S.tree_lock.Lock()
+	defer S.tree_lock.Unlock()
+
+	var file *fake_file
+
+	if upload.file_id > 0 {
+		var ok bool
+		if file, ok = S.files[upload.file_id]; !ok {
+			write_error(w, http.StatusNotFound, "ERR_ENTITY_NOT_FOUND", "File not found")
+			return
+		}
+		file.versions = append(file.versions, file.KWFile)
+		now := kwlib.WriteKWTime(time.Now())
+		file.Name = upload.filename
+		file.Modified = now
+		file.ClientModified = now
+		file.setContent(buffer.Bytes())
+	} else {
+		file = S.newFile(upload.folder_id, upload.filename, user.ID, buffer.Bytes())
+	}
+	if upload.modified != kwlib.NONE {
+		file.ClientModified = upload.modified
+	}
+
+	write_entity(w, r, http.StatusOK, file.KWFile)
+}
@@ -0,0 +1,321 @@
+/*
+	kwlibtest provides an in-process kiteworks stand-in for exercising code built on go-kwlib without a real appliance.
+*/
+
+package kwlibtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cmcoffee/go-kwlib"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fake kiteworks server.
+type Server struct {
+	*httptest.Server
+	ClientID      string        // Application ID clients must present.
+	ClientSecret  string        // Client secret key clients must present.
+	RedirectURI   string        // Redirect URI for the custom app.
+	TokenTTL      time.Duration // Lifetime of issued access tokens.
+	Prefix        string        // Path prefix kiteworks is mounted under, as with a reverse proxy.
+	signature_key string
+	next_id       int64
+	auth_lock     sync.RWMutex // Guards signature_key, users, tokens and refresh.
+	users         map[string]*fake_user
+	tokens        map[string]*fake_token
+	refresh       map[string]string
+	tree_lock     sync.RWMutex // Guards folders and files.
+	folders       map[int]*kwlib.KWFolder
+	files         map[int]*fake_file
+	upload_lock   sync.Mutex // Guards uploads.
+	uploads       map[int]*fake_upload
+	fail_lock     sync.Mutex // Guards failures.
+	failures      []*fake_failure
+}
+
+type fake_user struct {
+	kwlib.KWUser
+	password string
+}
+
+type fake_token struct {
+	email   string
+	expires time.Time
+}
+
+type fake_failure struct {
+	method  string
+	path    string
+	status  int
+	code    string
+	message string
+	count   int
+}
+
+// Starts a new fake kiteworks server listening on a local TLS port.
+func NewServer() *Server {
+	S := &Server{
+		ClientID:     "kwlibtest",
+		ClientSecret: "kwlibtest_secret",
+		RedirectURI:  "https://kwlibtest/redirect",
+		TokenTTL:     time.Hour,
+		users:        make(map[string]*fake_user),
+		tokens:       make(map[string]*fake_token),
+		refresh:      make(map[string]string),
+		folders:      make(map[int]*kwlib.KWFolder),
+		files:        make(map[int]*fake_file),
+		uploads:      make(map[int]*fake_upload),
+	}
+	S.Server = httptest.NewTLSServer(http.HandlerFunc(S.handle))
+	return S
+}
+
+// Host and port of the fake server.
+func (S *Server) Host() string {
+	u, err := url.Parse(S.URL)
+	if err != nil {
+		return kwlib.NONE
+	}
+	return u.Host
+}
+
+// Returns a KWAPI configured to talk to the fake server.
+func (S *Server) KWAPI() *kwlib.KWAPI {
+	K := &kwlib.KWAPI{
+		Server:         S.Host(),
+		BaseURL:        S.URL + S.Prefix,
+		ApplicationID:  S.ClientID,
+		RedirectURI:    S.RedirectURI,
+		AgentString:    "kwlibtest/1.0",
+		VerifySSL:      false,
+		RequestTimeout: 30 * time.Second,
+		ConnectTimeout: 10 * time.Second,
+		Retries:        3,
+		TokenStore:     kwlib.KVLiteStore(kwlib.OpenCache()),
+	}
+	K.ClientSecret(S.ClientSecret)
+	S.auth_lock.RLock()
+	if S.signature_key != kwlib.NONE {
+		K.Signature(S.signature_key)
+	}
+	S.auth_lock.RUnlock()
+	return K
+}
+
+// Enables signature based authorization_code grants using key.
+func (S *Server) SetSignature(key string) {
+	S.auth_lock.Lock()
+	defer S.auth_lock.Unlock()
+	S.signature_key = key
+}
+
+// Adds a user with a personal folder, returns the new user.
+func (S *Server) AddUser(email, password string) kwlib.KWUser {
+	email = strings.ToLower(email)
+	user := &fake_user{
+		KWUser: kwlib.KWUser{
+			ID:       S.newID(),
+			Name:     email,
+			Email:    email,
+			Created:  kwlib.WriteKWTime(time.Now()),
+			Active:   true,
+			Verified: true,
+		},
+		password: password,
+	}
+	S.tree_lock.Lock()
+	base := S.newFolder(0, "My Folder", user.ID)
+	S.tree_lock.Unlock()
+
+	user.BaseDirID = base.ID
+	user.MyDirID = base.ID
+
+	S.auth_lock.Lock()
+	S.users[email] = user
+	S.auth_lock.Unlock()
+	return user.KWUser
+}
+
+// Adds a folder under parent_id (0 for top level), returns the new folder.
+func (S *Server) AddFolder(parent_id int, name string) kwlib.KWFolder {
+	S.tree_lock.Lock()
+	defer S.tree_lock.Unlock()
+	return *S.newFolder(parent_id, name, 0)
+}
+
+// Adds a file to folder_id with the content provided, returns the new file.
+func (S *Server) AddFile(folder_id int, name string, content []byte) kwlib.KWFile {
+	S.tree_lock.Lock()
+	defer S.tree_lock.Unlock()
+	return S.newFile(folder_id, name, 0, content).KWFile
+}
+
+// Returns the current content of a file.
+func (S *Server) FileContent(file_id int) ([]byte, bool) {
+	S.tree_lock.RLock()
+	defer S.tree_lock.RUnlock()
+	if f, ok := S.files[file_id]; ok {
+		return append([]byte(nil), f.content...), true
+	}
+	return nil, false
+}
+
+// Expires all issued access tokens, forcing clients to refresh.
+func (S *Server) ExpireTokens() {
+	S.auth_lock.Lock()
+	defer S.auth_lock.Unlock()
+	for _, t := range S.tokens {
+		t.expires = time.Now().Add(-1 * time.Second)
+	}
+}
+
+// Fails the next count requests matching method and path prefix with a kiteworks error.
+func (S *Server) Fail(method, path string, status int, code string, count int) {
+	S.fail_lock.Lock()
+	defer S.fail_lock.Unlock()
+	S.failures = append(S.failures, &fake_failure{
+		method:  strings.ToUpper(method),
+		path:    path,
+		status:  status,
+		code:    code,
+		message: fmt.Sprintf("Injected failure for %s", path),
+		count:   count,
+	})
+}
+
+// Generates the next entity ID.
+func (S *Server) newID() int {
+	return int(atomic.AddInt64(&S.next_id, 1))
+}
+
+// Routes requests to their handlers.
+func (S *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if S.Prefix != kwlib.NONE {
+		if !strings.HasPrefix(r.URL.Path, S.Prefix+"/") {
+			write_error(w, http.StatusNotFound, "ERR_REQUEST_NOT_FOUND", "Not found")
+			return
+		}
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, S.Prefix)
+	}
+
+	if f := S.failure(r); f != nil {
+		write_error(w, f.status, f.code, f.message)
+		return
+	}
+
+	if r.URL.Path == "/oauth/token" {
+		S.handleToken(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/rest/") {
+		write_error(w, http.StatusNotFound, "ERR_REQUEST_NOT_FOUND", "Not found")
+		return
+	}
+
+	user := S.authorize(r)
+	if user == nil {
+		write_error(w, http.StatusUnauthorized, "ERR_AUTH_UNAUTHORIZED", "Unauthorized access token")
+		return
+	}
+
+	S.handleREST(w, r, user, strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/rest/"), "/"), "/"))
+}
+
+// Returns the injected failure matching r, if any, counting it as used.
+func (S *Server) failure(r *http.Request) *fake_failure {
+	S.fail_lock.Lock()
+	defer S.fail_lock.Unlock()
+	for i, f := range S.failures {
+		if f.count > 0 && (f.method == kwlib.NONE || f.method == r.Method) && strings.HasPrefix(r.URL.Path, f.path) {
+			f.count--
+			if f.count == 0 {
+				S.failures = append(S.failures[:i], S.failures[i+1:]...)
+			}
+			return f
+		}
+	}
+	return nil
+}
+
+// Returns user for bearer token on request, nil if invalid.
+func (S *Server) authorize(r *http.Request) *fake_user {
+	S.auth_lock.RLock()
+	defer S.auth_lock.RUnlock()
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil
+	}
+	token, ok := S.tokens[strings.TrimPrefix(auth, "Bearer ")]
+	if !ok || token.expires.Before(time.Now()) {
+		return nil
+	}
+	return S.users[token.email]
+}
+
+// Writes v as JSON with status code.
+func write_json(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// Writes a kiteworks style REST error.
+func write_error(w http.ResponseWriter, status int, code, message string) {
+	type kw_err struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	write_json(w, status, map[string]interface{}{"errors": []kw_err{{code, message}}})
+}
+
+// Writes an OAuth style error.
+func write_oauth_error(w http.ResponseWriter, status int, code, description string) {
+	write_json(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// Reads limit and offset from request, returns the requested window of items with metadata.
+func paginate(r *http.Request, items []interface{}) map[string]interface{} {
+	if items == nil {
+		items = make([]interface{}, 0)
+	}
+
+	total := len(items)
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	if offset < 0 || offset > total {
+		offset = total
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+
+	return map[string]interface{}{
+		"data": items[offset:end],
+		"metadata": map[string]interface{}{
+			"total":  total,
+			"offset": offset,
+			"limit":  limit,
+		},
+	}
+}
+
+// Sorts ids in place, returning them.
+func sorted_ids(ids []int) []int {
+	sort.Ints(ids)
+	return ids
+}
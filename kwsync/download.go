@@ -0,0 +1,187 @@
+package kwsync
+
+import (
+	"context"
+	"fmt"
+	"github.com/cmcoffee/go-kwlib"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mirrors a kiteworks folder down to a local directory.
+type Downloader struct {
+	Session  *kwlib.KWSession // Session used for all calls.
+	FolderID int              // kiteworks folder to mirror.
+	Local    string           // Local directory receiving the contents of FolderID.
+	DB       *kwlib.Database  // Keeps file state and download progress between runs, optional.
+}
+
+// Saved state for this sync.
+func (D *Downloader) state() state_table {
+	return state_table{D.DB, fmt.Sprintf("kwsync_download_%d", D.FolderID)}
+}
+
+// Mirrors FolderID into Local.
+func (D *Downloader) Run() (Result, error) {
+	return D.RunContext(context.Background())
+}
+
+// Run bound to ctx, partially downloaded files are resumed by the next run.
+//
+// The error returned is for failures which stop the run, failures of individual files are recorded in Result.
+func (D *Downloader) RunContext(ctx context.Context) (result Result, err error) {
+	root := filepath.Clean(D.Local)
+
+	type remote_folder struct {
+		rel string
+		id  int
+	}
+
+	queue := []remote_folder{{".", D.FolderID}}
+
+	for len(queue) > 0 {
+		if err = ctx.Err(); err != nil {
+			return result, err
+		}
+
+		folder := queue[0]
+		queue = queue[1:]
+
+		local_dir := filepath.Join(root, filepath.FromSlash(folder.rel))
+		if _, err := os.Stat(local_dir); os.IsNotExist(err) {
+			if err := os.MkdirAll(local_dir, 0755); err != nil {
+				if folder.rel == "." {
+					return result, err
+				}
+				result.fail(folder.rel, err)
+				continue
+			}
+			if folder.rel != "." {
+				result.Folders++
+			}
+		}
+
+		folders, files, err := D.Session.Folders().List(folder.id)
+		if err != nil {
+			if folder.rel == "." {
+				return result, err
+			}
+			result.fail(folder.rel, err)
+			continue
+		}
+
+		for _, f := range folders {
+			rel := join(folder.rel, f.Name)
+			if _, err := localPath(root, rel, f.Name); err != nil {
+				result.fail(rel, err)
+				continue
+			}
+			queue = append(queue, remote_folder{rel, f.ID})
+		}
+
+		for _, f := range files {
+			if err = ctx.Err(); err != nil {
+				return result, err
+			}
+			rel := join(folder.rel, f.Name)
+			dest, err := localPath(root, rel, f.Name)
+			if err != nil {
+				result.fail(rel, err)
+				continue
+			}
+			D.syncFile(ctx, rel, dest, f, &result)
+		}
+	}
+
+	return result, nil
+}
+
+// Returns the local path for the relative path rel under root, refusing names from kiteworks which would escape root.
+func localPath(root, rel, name string) (string, error) {
+	if name == kwlib.NONE || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") || filepath.IsAbs(name) || filepath.VolumeName(name) != kwlib.NONE {
+		return kwlib.NONE, fmt.Errorf("%q can not be used as a local name.", name)
+	}
+	dest := filepath.Join(root, filepath.FromSlash(rel))
+	if r, err := filepath.Rel(root, dest); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return kwlib.NONE, fmt.Errorf("%q is outside of %s.", rel, root)
+	}
+	return dest, nil
+}
+
+// Brings the local copy of a kiteworks file up to date.
+func (D *Downloader) syncFile(ctx context.Context, rel, dest string, remote kwlib.KWFile, result *Result) {
+	mod_time, err := remote.ModifiedTime()
+	if err != nil {
+		result.fail(rel, err)
+		return
+	}
+
+	var state file_state
+	found := D.state().get(rel, &state)
+
+	finfo, err := os.Stat(dest)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		result.fail(rel, err)
+		return
+	}
+
+	if exists && finfo.Size() == remote.Size {
+		// Nothing has changed on either side since the last run.
+		if found && !state.Partial && state.FileID == remote.ID && state.Size == remote.Size &&
+			state.ModTime == finfo.ModTime().UnixNano() && strings.EqualFold(state.MD5, remote.Fingerprint) {
+			result.Skipped++
+			return
+		}
+		if remote.Fingerprint != kwlib.NONE {
+			sum, err := kwlib.MD5Sum(dest)
+			if err != nil {
+				result.fail(rel, err)
+				return
+			}
+			if strings.EqualFold(sum, remote.Fingerprint) {
+				if err := os.Chtimes(dest, mod_time, mod_time); err != nil {
+					kwlib.Debug("[kwsync] %s: %s", rel, err.Error())
+				}
+				D.saveState(rel, dest, remote)
+				result.Skipped++
+				return
+			}
+		}
+	}
+
+	// A partial download left from a previous run is only resumed if it belongs to the same content.
+	if found && state.Partial && (state.FileID != remote.ID || !strings.EqualFold(state.MD5, remote.Fingerprint) || state.Size != remote.Size) {
+		if err := os.Remove(dest + ".incomplete"); err != nil && !os.IsNotExist(err) {
+			result.fail(rel, err)
+			return
+		}
+	}
+	D.state().set(rel, file_state{Size: remote.Size, MD5: remote.Fingerprint, FileID: remote.ID, Partial: true})
+
+	if err := D.Session.DownloadFileContext(ctx, remote.ID, dest); err != nil {
+		result.fail(rel, err)
+		return
+	}
+
+	if err := os.Chtimes(dest, mod_time, mod_time); err != nil {
+		kwlib.Debug("[kwsync] %s: %s", rel, err.Error())
+	}
+	D.saveState(rel, dest, remote)
+
+	if exists {
+		result.Replaced++
+	} else {
+		result.Fetched++
+	}
+}
+
+// Records dest as a complete copy of remote.
+func (D *Downloader) saveState(rel, dest string, remote kwlib.KWFile) {
+	state := file_state{Size: remote.Size, MD5: remote.Fingerprint, FileID: remote.ID}
+	if finfo, err := os.Stat(dest); err == nil {
+		state.ModTime = finfo.ModTime().UnixNano()
+	}
+	D.state().set(rel, state)
+}
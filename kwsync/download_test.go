@@ -0,0 +1,109 @@
+package kwsync_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cmcoffee/go-kwlib"
+	"github.com/cmcoffee/go-kwlib/kwsync"
+)
+
+func TestDownloader(t *testing.T) {
+	_, s, user := testSession(t)
+
+	source := t.TempDir()
+	writeFiles(t, source, map[string]string{
+		"top.txt":     "top",
+		"a/b/two.txt": "two two two",
+	})
+	if err := os.MkdirAll(filepath.Join(source, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	upload := &kwsync.Uploader{Session: s, Local: source, FolderID: user.BaseDirID}
+	if result, err := upload.Run(); err != nil || result.Err() != nil {
+		t.Fatalf("upload: %+v, %v", result, err)
+	}
+
+	local := filepath.Join(t.TempDir(), "out", "deep")
+	D := &kwsync.Downloader{Session: s, FolderID: user.BaseDirID, Local: local, DB: kwlib.OpenCache()}
+
+	result, err := D.Run()
+	if err != nil || result.Err() != nil || result.Fetched != 2 || result.Folders != 3 {
+		t.Fatalf("first run: %+v, %v", result, err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(local, "a", "b", "two.txt")); err != nil || string(content) != "two two two" {
+		t.Fatalf("downloaded %q, %v", content, err)
+	}
+	if finfo, err := os.Stat(filepath.Join(local, "empty")); err != nil || !finfo.IsDir() {
+		t.Fatalf("empty folder not created: %v", err)
+	}
+
+	// Local files take the modified time of the remote file.
+	_, files, err := s.Folders().List(user.BaseDirID)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("remote files %+v, %v", files, err)
+	}
+	mod_time, err := files[0].ModifiedTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finfo, err := os.Stat(filepath.Join(local, files[0].Name)); err != nil || !finfo.ModTime().Equal(mod_time) {
+		t.Fatalf("local file modified %v, expected %s", finfo.ModTime(), mod_time)
+	}
+
+	result, err = D.Run()
+	if err != nil || result.Skipped != 2 || result.Fetched+result.Replaced+result.Folders != 0 {
+		t.Fatalf("second run: %+v, %v", result, err)
+	}
+
+	// An interrupted download is resumed and a remote change replaces the local copy.
+	if err := os.Remove(filepath.Join(local, "a", "b", "two.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, local, map[string]string{"a/b/two.txt.incomplete": "two "})
+	writeFiles(t, source, map[string]string{"top.txt": "top changed"})
+	if result, err := upload.Run(); err != nil || result.Versioned != 1 {
+		t.Fatalf("upload: %+v, %v", result, err)
+	}
+
+	result, err = D.Run()
+	if err != nil || result.Err() != nil || result.Fetched != 1 || result.Replaced != 1 {
+		t.Fatalf("third run: %+v, %v", result, err)
+	}
+	for name, expected := range map[string]string{"a/b/two.txt": "two two two", "top.txt": "top changed"} {
+		if content, err := ioutil.ReadFile(filepath.Join(local, filepath.FromSlash(name))); err != nil || string(content) != expected {
+			t.Fatalf("%s is %q, %v, expected %q", name, content, err, expected)
+		}
+	}
+}
+
+func TestDownloaderTraversal(t *testing.T) {
+	srv, s, user := testSession(t)
+
+	srv.AddFile(user.BaseDirID, "safe.txt", []byte("safe"))
+	srv.AddFile(user.BaseDirID, "../escaped.txt", []byte("escaped"))
+	srv.AddFile(user.BaseDirID, `..\escaped.txt`, []byte("escaped"))
+	up := srv.AddFolder(user.BaseDirID, "..")
+	srv.AddFile(up.ID, "escaped.txt", []byte("escaped"))
+	nested := srv.AddFolder(user.BaseDirID, "a/../..")
+	srv.AddFile(nested.ID, "escaped.txt", []byte("escaped"))
+
+	parent := t.TempDir()
+	local := filepath.Join(parent, "out")
+
+	result, err := (&kwsync.Downloader{Session: s, FolderID: user.BaseDirID, Local: local}).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Fetched != 1 || len(result.Errors) != 4 {
+		t.Fatalf("fetched %d, failed %v, expected 1 and 4", result.Fetched, result.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); !os.IsNotExist(err) {
+		t.Fatalf("file written outside of %s", local)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(local, "safe.txt")); err != nil || string(content) != "safe" {
+		t.Fatalf("safe.txt is %q, %v", content, err)
+	}
+}
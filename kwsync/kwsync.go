@@ -0,0 +1,129 @@
+/*
+	kwsync mirrors folder trees between local disk and kiteworks, keeping state in a kwlib Database so later runs only transfer what changed.
+*/
+
+package kwsync
+
+import (
+	"fmt"
+	"github.com/cmcoffee/go-kwlib"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Outcome of a sync run.
+type Result struct {
+	Folders   int              // Folders created.
+	Uploaded  int              // New files sent.
+	Versioned int              // Changed files sent as new versions.
+	Fetched   int              // New files downloaded.
+	Replaced  int              // Changed files downloaded over the local copy.
+	Skipped   int              // Files already in sync.
+	Deleted   int              // Files and folders removed.
+	Errors    map[string]error // Failures, keyed by relative path.
+}
+
+// Records err against the relative path rel.
+func (r *Result) fail(rel string, err error) {
+	if r.Errors == nil {
+		r.Errors = make(map[string]error)
+	}
+	kwlib.Debug("[kwsync] %s: %s", rel, err.Error())
+	r.Errors[rel] = err
+}
+
+// Returns nil if nothing failed, otherwise an error summarizing the failures.
+func (r Result) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(r.Errors))
+	for k := range r.Errors {
+		paths = append(paths, k)
+	}
+	sort.Strings(paths)
+	msg := make([]string, len(paths))
+	for i, p := range paths {
+		msg[i] = fmt.Sprintf("%s: %s", p, r.Errors[p].Error())
+	}
+	return fmt.Errorf("%d failed: %s", len(paths), strings.Join(msg, "; "))
+}
+
+// Last synchronized state of a file.
+type file_state struct {
+	Size    int64  // Size in bytes.
+	ModTime int64  // Local modification time, in unix nanoseconds.
+	MD5     string // MD5 of the content.
+	FileID  int    // kiteworks file ID.
+	Partial bool   // Download was started but has not completed.
+}
+
+// Saved file states for a sync, kept in a Database table.
+type state_table struct {
+	db   *kwlib.Database
+	name string
+}
+
+// Loads saved state for rel.
+func (t state_table) get(rel string, state *file_state) bool {
+	if t.db == nil {
+		return false
+	}
+	found, err := t.db.TryGet(t.name, rel, state)
+	if err != nil {
+		kwlib.Debug("[kwsync] %s: %s", rel, err.Error())
+		return false
+	}
+	return found
+}
+
+// Saves state for rel.
+func (t state_table) set(rel string, state file_state) {
+	if t.db == nil {
+		return
+	}
+	if err := t.db.TrySet(t.name, rel, state); err != nil {
+		kwlib.Debug("[kwsync] %s: %s", rel, err.Error())
+	}
+}
+
+// Removes saved state for rel.
+func (t state_table) unset(rel string) {
+	if t.db == nil {
+		return
+	}
+	if err := t.db.TryUnset(t.name, rel); err != nil {
+		kwlib.Debug("[kwsync] %s: %s", rel, err.Error())
+	}
+}
+
+// Removes saved state for all files beneath the folder rel.
+func (t state_table) unsetFolder(rel string) {
+	if t.db == nil {
+		return
+	}
+	keys, err := t.db.TryKeys(t.name)
+	if err != nil {
+		kwlib.Debug("[kwsync] %s: %s", rel, err.Error())
+		return
+	}
+	for _, k := range keys {
+		if strings.HasPrefix(k, rel+"/") {
+			t.unset(k)
+		}
+	}
+}
+
+// Returns the parent of the slash separated relative path rel, "." for top level entries.
+func parent(rel string) string {
+	return path.Dir(rel)
+}
+
+// Joins a relative folder path with name.
+func join(rel, name string) string {
+	if rel == "." {
+		return name
+	}
+	return rel + "/" + name
+}
@@ -0,0 +1,291 @@
+package kwsync
+
+import (
+	"context"
+	"fmt"
+	"github.com/cmcoffee/go-kwlib"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mirrors a local directory into a kiteworks folder.
+type Uploader struct {
+	Session  *kwlib.KWSession // Session used for all calls.
+	Local    string           // Local directory to mirror.
+	FolderID int              // kiteworks folder receiving the contents of Local.
+	DB       *kwlib.Database  // Keeps file state between runs, optional.
+	Delete   bool             // Remove remote files and folders which no longer exist locally.
+}
+
+// Local folder and its contents.
+type local_folder struct {
+	folders []string
+	files   []kwlib.FileInfo
+}
+
+// Saved state for this sync.
+func (U *Uploader) state() state_table {
+	return state_table{U.DB, fmt.Sprintf("kwsync_upload_%d", U.FolderID)}
+}
+
+// Mirrors Local into FolderID.
+func (U *Uploader) Run() (Result, error) {
+	return U.RunContext(context.Background())
+}
+
+// Run bound to ctx, files not reached before ctx is done are left for the next run.
+//
+// The error returned is for failures which stop the run, failures of individual files are recorded in Result.
+func (U *Uploader) RunContext(ctx context.Context) (result Result, err error) {
+	root := filepath.Clean(U.Local)
+	info, err := os.Stat(root)
+	if err != nil {
+		return result, err
+	}
+	if !info.IsDir() {
+		return result, fmt.Errorf("%s: not a directory.", root)
+	}
+
+	tree, order, err := scanLocal(root)
+	if err != nil {
+		return result, err
+	}
+
+	folder_ids := map[string]int{".": U.FolderID}
+
+	var (
+		delete_files   []int
+		delete_folders []int
+		deleted_paths  = make(map[int]string)
+	)
+
+	for _, rel := range order {
+		if err = ctx.Err(); err != nil {
+			return result, err
+		}
+
+		folder_id, ok := folder_ids[rel]
+		if !ok {
+			continue
+		}
+
+		remote_folders, remote_files, err := U.Session.Folders().List(folder_id)
+		if err != nil {
+			if rel == "." {
+				return result, err
+			}
+			result.fail(rel, err)
+			continue
+		}
+
+		folders := make(map[string]kwlib.KWFolder, len(remote_folders))
+		for _, f := range remote_folders {
+			folders[strings.ToLower(f.Name)] = f
+		}
+		files := make(map[string]kwlib.KWFile, len(remote_files))
+		for _, f := range remote_files {
+			files[strings.ToLower(f.Name)] = f
+		}
+
+		local := tree[rel]
+
+		// kiteworks does not distinguish names by case, so only the first of names differing only in case is synced.
+		seen := make(map[string]string)
+		unique := func(child, name string) bool {
+			if other, ok := seen[strings.ToLower(name)]; ok {
+				result.fail(child, fmt.Errorf("%s differs only in case from %s.", name, other))
+				return false
+			}
+			seen[strings.ToLower(name)] = name
+			return true
+		}
+
+		for _, name := range local.folders {
+			child := join(rel, name)
+			if !unique(child, name) {
+				continue
+			}
+			if f, ok := folders[strings.ToLower(name)]; ok {
+				folder_ids[child] = f.ID
+				delete(folders, strings.ToLower(name))
+				continue
+			}
+			f, err := U.Session.Folders().Create(folder_id, name)
+			if err != nil {
+				result.fail(child, err)
+				continue
+			}
+			kwlib.Debug("[kwsync] %s: created folder %d.", child, f.ID)
+			folder_ids[child] = f.ID
+			result.Folders++
+		}
+
+		for _, finfo := range local.files {
+			if err = ctx.Err(); err != nil {
+				return result, err
+			}
+			name := finfo.Info.Name()
+			child := join(rel, name)
+			if !unique(child, name) {
+				continue
+			}
+			var remote *kwlib.KWFile
+			if f, ok := files[strings.ToLower(name)]; ok {
+				remote = &f
+				delete(files, strings.ToLower(name))
+			}
+			U.syncFile(ctx, folder_id, child, finfo, remote, &result)
+		}
+
+		if U.Delete {
+			for _, f := range folders {
+				delete_folders = append(delete_folders, f.ID)
+				deleted_paths[f.ID] = join(rel, f.Name)
+			}
+			for _, f := range files {
+				delete_files = append(delete_files, f.ID)
+				deleted_paths[f.ID] = join(rel, f.Name)
+			}
+		}
+	}
+
+	if len(delete_files) > 0 {
+		for id, err := range U.Session.Files().DeleteMany(delete_files) {
+			if err != nil {
+				result.fail(deleted_paths[id], err)
+				continue
+			}
+			U.state().unset(deleted_paths[id])
+			result.Deleted++
+		}
+	}
+
+	if len(delete_folders) > 0 {
+		for id, err := range U.Session.Folders().DeleteMany(delete_folders) {
+			if err != nil {
+				result.fail(deleted_paths[id], err)
+				continue
+			}
+			U.state().unsetFolder(deleted_paths[id])
+			result.Deleted++
+		}
+	}
+
+	return result, nil
+}
+
+// Walks root, returning the contents of each folder keyed by slash separated relative path, and the folders in walk order.
+func scanLocal(root string) (tree map[string]*local_folder, order []string, err error) {
+	folders, files := kwlib.ScanPath(root)
+	tree = make(map[string]*local_folder, len(folders))
+
+	rel := func(p string) (string, error) {
+		r, err := filepath.Rel(root, p)
+		if err != nil {
+			return kwlib.NONE, err
+		}
+		return filepath.ToSlash(r), nil
+	}
+
+	for _, f := range folders {
+		r, err := rel(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		tree[r] = new(local_folder)
+		order = append(order, r)
+		if r != "." {
+			tree[parent(r)].folders = append(tree[parent(r)].folders, filepath.Base(f))
+		}
+	}
+
+	for _, f := range files {
+		if !f.Info.Mode().IsRegular() {
+			continue
+		}
+		r, err := rel(f.Path())
+		if err != nil {
+			return nil, nil, err
+		}
+		tree[parent(r)].files = append(tree[parent(r)].files, f)
+	}
+
+	return tree, order, nil
+}
+
+// Brings the remote copy of a local file up to date, remote is nil when the file does not exist in kiteworks.
+func (U *Uploader) syncFile(ctx context.Context, folder_id int, rel string, local kwlib.FileInfo, remote *kwlib.KWFile, result *Result) {
+	size := local.Info.Size()
+	mod_time := local.Info.ModTime()
+
+	var state file_state
+	found := U.state().get(rel, &state)
+
+	if remote != nil {
+		// Nothing has changed on either side since the last run.
+		if found && state.FileID == remote.ID && state.Size == size && state.ModTime == mod_time.UnixNano() && remote.Size == size &&
+			(remote.Fingerprint == kwlib.NONE || strings.EqualFold(remote.Fingerprint, state.MD5)) {
+			result.Skipped++
+			return
+		}
+		if remote.Size == size {
+			sum, err := kwlib.MD5Sum(local.Path())
+			if err != nil {
+				result.fail(rel, err)
+				return
+			}
+			same := strings.EqualFold(remote.Fingerprint, sum)
+			if remote.Fingerprint == kwlib.NONE {
+				if t, err := remote.ModifiedTime(); err == nil {
+					same = t.Equal(mod_time.Truncate(time.Second))
+				}
+			}
+			if same {
+				U.state().set(rel, file_state{Size: size, ModTime: mod_time.UnixNano(), MD5: sum, FileID: remote.ID})
+				result.Skipped++
+				return
+			}
+		}
+	}
+
+	f, err := os.Open(local.Path())
+	if err != nil {
+		result.fail(rel, err)
+		return
+	}
+	defer f.Close()
+
+	// Keep the local modified time, so later runs can compare it with the remote file.
+	modified := kwlib.PostJSON{"clientModified": kwlib.WriteKWTime(mod_time)}
+
+	var upload_id int
+	if remote == nil {
+		upload_id, err = U.Session.NewUpload(folder_id, local.Info.Name(), size, modified)
+	} else {
+		upload_id, err = U.Session.NewVersion(remote.ID, local.Info.Name(), size, modified)
+	}
+	if err != nil {
+		result.fail(rel, err)
+		return
+	}
+
+	file_id, err := U.Session.UploadContext(ctx, local.Info.Name(), upload_id, f)
+	if err != nil {
+		result.fail(rel, err)
+		return
+	}
+
+	if remote == nil {
+		result.Uploaded++
+	} else {
+		result.Versioned++
+	}
+
+	sum, err := kwlib.MD5Sum(local.Path())
+	if err != nil {
+		kwlib.Debug("[kwsync] %s: %s", rel, err.Error())
+	}
+	U.state().set(rel, file_state{Size: size, ModTime: mod_time.UnixNano(), MD5: sum, FileID: file_id})
+}
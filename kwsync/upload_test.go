@@ -0,0 +1,156 @@
+package kwsync_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cmcoffee/go-kwlib"
+	"github.com/cmcoffee/go-kwlib/kwlibtest"
+	"github.com/cmcoffee/go-kwlib/kwsync"
+)
+
+// Starts a fake kiteworks with a single user, returning a session authenticated as that user.
+func testSession(t *testing.T) (*kwlibtest.Server, *kwlib.KWSession, kwlib.KWUser) {
+	srv := kwlibtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.SetSignature("signature_key")
+	user := srv.AddUser("user@example.com", "password")
+	s, err := srv.KWAPI().Authenticate(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, s, user
+}
+
+// Writes files under root, keyed by slash separated path.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUploader(t *testing.T) {
+	_, s, user := testSession(t)
+
+	local := t.TempDir()
+	writeFiles(t, local, map[string]string{
+		"top.txt":     "top",
+		"a/one.txt":   "one",
+		"a/b/two.txt": "two",
+		"gone/x.txt":  "x",
+	})
+
+	db := kwlib.OpenCache()
+	U := &kwsync.Uploader{Session: s, Local: local, FolderID: user.BaseDirID, DB: db, Delete: true}
+
+	result, err := U.Run()
+	if err != nil || result.Err() != nil || result.Uploaded != 4 || result.Folders != 3 {
+		t.Fatalf("first run: %+v, %v", result, err)
+	}
+
+	// Nothing changed, nothing is sent.
+	result, err = U.Run()
+	if err != nil || result.Err() != nil || result.Skipped != 4 || result.Uploaded+result.Versioned+result.Folders+result.Deleted != 0 {
+		t.Fatalf("second run: %+v, %v", result, err)
+	}
+
+	// A touched file with the same content is skipped, changed files are versioned and removed folders deleted.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(local, "top.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, local, map[string]string{
+		"a/one.txt":   "ONE",
+		"a/b/two.txt": "two, longer",
+	})
+	if err := os.RemoveAll(filepath.Join(local, "gone")); err != nil {
+		t.Fatal(err)
+	}
+	result, err = U.Run()
+	if err != nil || result.Err() != nil || result.Skipped != 1 || result.Versioned != 2 || result.Deleted != 1 {
+		t.Fatalf("third run: %+v, %v", result, err)
+	}
+
+	folders, _, err := s.Folders().List(user.BaseDirID)
+	if err != nil || len(folders) != 1 || folders[0].Name != "a" {
+		t.Fatalf("remote folders %+v, %v", folders, err)
+	}
+
+	result, err = U.Run()
+	if err != nil || result.Skipped != 3 {
+		t.Fatalf("fourth run: %+v, %v", result, err)
+	}
+	if keys := db.Keys("kwsync_upload_" + strconv.Itoa(user.BaseDirID)); len(keys) != 3 {
+		t.Fatalf("state kept for %v, expected 3 files", keys)
+	}
+}
+
+func TestUploaderModified(t *testing.T) {
+	_, s, user := testSession(t)
+
+	local := t.TempDir()
+	writeFiles(t, local, map[string]string{
+		"top.txt":   "top",
+		"a/one.txt": "one",
+	})
+	earlier := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	for _, name := range []string{"top.txt", "a/one.txt"} {
+		if err := os.Chtimes(filepath.Join(local, filepath.FromSlash(name)), earlier, earlier); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without a database, later runs rely on what kiteworks reports.
+	U := &kwsync.Uploader{Session: s, Local: local, FolderID: user.BaseDirID}
+	if result, err := U.Run(); err != nil || result.Err() != nil || result.Uploaded != 2 {
+		t.Fatalf("first run: %+v, %v", result, err)
+	}
+
+	_, files, err := s.Folders().List(user.BaseDirID)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("remote files %+v, %v", files, err)
+	}
+	if mod_time, err := files[0].ModifiedTime(); err != nil || !mod_time.Equal(earlier) {
+		t.Fatalf("remote file modified %s, %v, expected %s", mod_time, err, earlier)
+	}
+
+	result, err := U.Run()
+	if err != nil || result.Err() != nil || result.Skipped != 2 || result.Uploaded+result.Versioned != 0 {
+		t.Fatalf("second run: %+v, %v", result, err)
+	}
+}
+
+func TestUploaderCase(t *testing.T) {
+	_, s, user := testSession(t)
+
+	local := t.TempDir()
+	writeFiles(t, local, map[string]string{
+		"Note.txt": "upper",
+		"note.txt": "lower",
+	})
+	if entries, err := ioutil.ReadDir(local); err != nil || len(entries) != 2 {
+		t.Skip("file system does not distinguish names by case")
+	}
+
+	U := &kwsync.Uploader{Session: s, Local: local, FolderID: user.BaseDirID}
+	result, err := U.Run()
+	if err != nil || result.Uploaded != 1 || len(result.Errors) != 1 {
+		t.Fatalf("first run: %+v, %v", result, err)
+	}
+
+	// The file synced is left alone, rather than replaced by the other each run.
+	result, err = U.Run()
+	if err != nil || result.Skipped != 1 || result.Uploaded+result.Versioned != 0 || len(result.Errors) != 1 {
+		t.Fatalf("second run: %+v, %v", result, err)
+	}
+}